@@ -16,6 +16,9 @@ robots=(
     "github.com/wojtekzw/slackbot/robots/ping"
     "github.com/wojtekzw/slackbot/robots/block"
     "github.com/wojtekzw/slackbot/robots/bots"
+    "github.com/wojtekzw/slackbot/robots/burn"
+    "github.com/wojtekzw/slackbot/robots/whoisin"
+    "github.com/wojtekzw/slackbot/robots/digest"
 )
 
 echo "package importer
@@ -6,10 +6,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/justinas/alice"
+	"github.com/nlopes/slack"
 	"github.com/wojtekzw/slackbot/Godeps/_workspace/src/github.com/gorilla/schema"
 	_ "github.com/wojtekzw/slackbot/importer"
 	"github.com/wojtekzw/slackbot/robots"
@@ -25,9 +30,19 @@ func main() {
 	http.Handle("/", stdChain.ThenFunc(http.NotFound))
 	http.Handle("/slack", stdChain.ThenFunc(slashCommandHandler))
 	http.Handle("/slack_hook", stdChain.ThenFunc(hookHandler))
+	http.Handle("/slack_events", stdChain.ThenFunc(eventsHandler))
 
 	go rtm.RunRTM()
 
+	// Save RTM state kept in memory on stop
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		rtm.Shutdown()
+		os.Exit(0)
+	}()
+
 	// go startServer()
 
 	startTLSServer()
@@ -82,17 +97,117 @@ func hookHandler(w http.ResponseWriter, r *http.Request) {
 	command.Robot = c[0]
 	command.Text = strings.Join(c[1:], " ")
 
-	robots := getRobots(command.Robot)
-	if len(robots) == 0 {
-		jsonResp(w, "No robot for that command yet :(")
+	matched := getRobots(command.Robot)
+	if len(matched) == 0 {
+		hookResp(w, &command.Payload, "No robot for that command yet :(")
 		return
 	}
 	resp := ""
-	for _, robot := range robots {
+	for _, robot := range matched {
 		resp += fmt.Sprintf("\n%s", robot.Run(&command.Payload))
 	}
+	hookResp(w, &command.Payload, strings.TrimSpace(resp))
+}
+
+// hookResp - answer outgoing webhook. Webhook response is always posted at channel top level,
+// so answer to a message in a thread is posted as a reply in the thread instead
+func hookResp(w http.ResponseWriter, p *robots.Payload, msg string) {
+	if msg == "" {
+		// Robot answers later (or not at all) - empty body posts nothing
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if p.ThreadTimestamp == "" {
+		jsonResp(w, msg)
+		return
+	}
+	go func() {
+		if err := p.Reply("", msg).Send().Wait(); err != nil {
+			log.Printf("Error sending %s reply in thread: %v", p.Robot, err)
+		}
+	}()
 	w.WriteHeader(http.StatusOK)
-	jsonResp(w, strings.TrimSpace(resp))
+}
+
+// eventsAPIRequest - Events API request: URL verification or event callback
+type eventsAPIRequest struct {
+	Token     string          `json:"token"`
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// eventRetention - how long event IDs are remembered. Slack retries an event 3 times within about an hour
+const eventRetention = time.Hour
+
+// seenEvents - IDs of handled Events API events, so retries of the same event don't run robots again
+type seenEvents struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+var handledEvents = &seenEvents{ids: make(map[string]time.Time)}
+
+// seen - true if event was already handled, otherwise remember it
+func (s *seenEvents) seen(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for old, t := range s.ids {
+		if now.Sub(t) >= eventRetention {
+			delete(s.ids, old)
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return true
+	}
+	s.ids[id] = now
+	return false
+}
+
+// eventsHandler - Events API endpoint. Messages run robots like in RTM (see robots.TriggerPrefix)
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	req := new(eventsAPIRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Println("Couldn't parse events request:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if token := getEventsToken(); token == "" || req.Token != token {
+		log.Printf("[DEBUG] Ignoring event from unidentified source: %s - %s", req.Token, r.Host)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch req.Type {
+	case "url_verification":
+		plainResp(w, req.Challenge)
+	case "event_callback":
+		// Slack retries events it thinks were lost (X-Slack-Retry-Num) - ack them without running robots again
+		if req.EventID != "" && handledEvents.seen(req.EventID, time.Now()) {
+			log.Printf("Ignoring repeated event %s (retry %s)", req.EventID, r.Header.Get("X-Slack-Retry-Num"))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		msg := new(slack.Msg)
+		if err := json.Unmarshal(req.Event, msg); err != nil {
+			log.Println("Couldn't parse event:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if msg.Type == "message" {
+			if msg.Team == "" {
+				msg.Team = req.TeamID
+			}
+			// Robots run in background - Slack retries events not acknowledged in 3 seconds
+			robots.RunMessage(msg, "")
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
 func slashCommandHandler(w http.ResponseWriter, r *http.Request) {
@@ -155,6 +270,10 @@ func getOutToken(teamDomain string) string {
 	return os.Getenv(fmt.Sprintf("%s_OUT_TOKEN", strings.ToUpper(teamDomain)))
 }
 
+func getEventsToken() string {
+	return os.Getenv("SLACKBOT_EVENTS_TOKEN")
+}
+
 func getTLSCert() string {
 	return os.Getenv("SLACKBOT_TLS_CERT")
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wojtekzw/slackbot/robots"
+)
+
+func postEvent(body string, retry string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/slack_events", strings.NewReader(body))
+	if retry != "" {
+		r.Header.Set("X-Slack-Retry-Num", retry)
+	}
+	w := httptest.NewRecorder()
+	eventsHandler(w, r)
+	return w
+}
+
+func TestEventsURLVerification(t *testing.T) {
+	t.Setenv("SLACKBOT_EVENTS_TOKEN", "secret")
+
+	w := postEvent(`{"token":"secret","type":"url_verification","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Body.String(); got != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("body = %q, want the challenge", got)
+	}
+}
+
+func TestEventsTokenRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		body   string
+	}{
+		{"wrong token", "secret", `{"token":"other","type":"url_verification","challenge":"c"}`},
+		{"missing token", "secret", `{"type":"url_verification","challenge":"c"}`},
+		{"events not configured", "", `{"token":"","type":"url_verification","challenge":"c"}`},
+		{"not JSON", "secret", `token=secret`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SLACKBOT_EVENTS_TOKEN", tt.config)
+			w := postEvent(tt.body, "")
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+			if strings.Contains(w.Body.String(), "c") {
+				t.Errorf("challenge answered: %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestEventsRetryAcked(t *testing.T) {
+	t.Setenv("SLACKBOT_EVENTS_TOKEN", "secret")
+	body := `{"token":"secret","type":"event_callback","event_id":"Ev0000RETRY","event":{"type":"reaction_added"}}`
+
+	if w := postEvent(body, ""); w.Code != http.StatusOK {
+		t.Fatalf("first delivery status = %d", w.Code)
+	}
+	if !handledEvents.seen("Ev0000RETRY", time.Now()) {
+		t.Fatal("event ID not remembered")
+	}
+	if w := postEvent(body, "1"); w.Code != http.StatusOK {
+		t.Errorf("retry status = %d, want 200 so Slack stops retrying", w.Code)
+	}
+}
+
+func TestSeenEvents(t *testing.T) {
+	s := &seenEvents{ids: make(map[string]time.Time)}
+	start := time.Now()
+
+	if s.seen("Ev1", start) {
+		t.Error("new event reported as seen")
+	}
+	if !s.seen("Ev1", start.Add(time.Minute)) {
+		t.Error("retry not reported as seen")
+	}
+	if s.seen("Ev1", start.Add(eventRetention)) {
+		t.Error("event remembered after retention")
+	}
+	s.seen("Ev2", start.Add(3*eventRetention))
+	if len(s.ids) != 1 {
+		t.Errorf("old event IDs not pruned: %v", s.ids)
+	}
+}
+
+// echoRobot - answers with the command text
+type echoRobot struct{}
+
+func (echoRobot) Run(p *robots.Payload) string { return "echo " + p.Text }
+func (echoRobot) Description() string          { return "echo" }
+
+func TestHookReplyInThread(t *testing.T) {
+	posted := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted <- r.FormValue("payload")
+	}))
+	defer srv.Close()
+	t.Setenv("TEAM_OUT_TOKEN", "secret")
+	t.Setenv("TEAM_IN_URL", srv.URL)
+	robots.Robots["echotest"] = []robots.Robot{echoRobot{}}
+	defer delete(robots.Robots, "echotest")
+
+	hook := func(form url.Values) string {
+		r := httptest.NewRequest("POST", "/slack_hook", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		hookHandler(w, r)
+		return w.Body.String()
+	}
+	form := url.Values{
+		"token": {"secret"}, "team_domain": {"team"}, "channel_id": {"C0123ABCD"},
+		"trigger_word": {"bot"}, "text": {"bot echotest hi"},
+	}
+
+	if got := hook(form); got != `{"text":"echo hi"}` {
+		t.Errorf("top level response = %s", got)
+	}
+
+	form.Set("thread_ts", "1500000000.000100")
+	if got := hook(form); got != "" {
+		t.Errorf("thread response = %s, want empty - reply goes to thread", got)
+	}
+	select {
+	case payload := <-posted:
+		if !strings.Contains(payload, `"thread_ts":"1500000000.000100"`) || !strings.Contains(payload, "echo hi") {
+			t.Errorf("thread reply payload = %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply posted to thread")
+	}
+}
@@ -0,0 +1,180 @@
+package mrkdwn
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	headingRe   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	bulletRe    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	quoteRe     = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	ruleRe      = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	fenceRe     = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+	autolinkRe  = regexp.MustCompile(`^<((?:https?|mailto|ftp):[^<>\s]+)>`)
+	mdLinkRe    = regexp.MustCompile(`^!?\[([^\]]*)\]\(\s*([^()\s]+)(?:\s+"[^"]*")?\s*\)`)
+	ruleReplace = "──────────"
+)
+
+// FromMarkdown - convert CommonMark (e.g. README or generated text) to Slack mrkdwn:
+// **bold** and __bold__ to *bold*, *italic* to _italic_, ~~strike~~ to ~strike~, [label](url) to links,
+// headings to bold lines and bullets to •. Code is kept, everything else is escaped
+func FromMarkdown(md string) string {
+	var out []string
+	lines := strings.Split(strings.Replace(md, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			out = append(out, "```\n"+Escape(strings.Join(code, "\n"))+"\n```")
+			continue
+		}
+
+		switch {
+		case ruleRe.MatchString(line):
+			out = append(out, ruleReplace)
+		case headingRe.MatchString(line):
+			// Slack doesn't nest bold - drop bold inside heading
+			heading := strings.Replace(convertInline(headingRe.FindStringSubmatch(line)[1]), "*", "", -1)
+			out = append(out, "*"+heading+"*")
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+convertInline(m[2]))
+		case quoteRe.MatchString(line):
+			out = append(out, "> "+convertInline(quoteRe.FindStringSubmatch(line)[1]))
+		default:
+			out = append(out, convertInline(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// convertInline - convert emphasis, code spans and links of one line
+func convertInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte("\\`*_{}[]()#+-.!~<>|", rest[1]) >= 0:
+			b.WriteString(Escape(rest[1:2]))
+			i += 2
+			continue
+
+		case rest[0] == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				code := strings.TrimSpace(rest[ticks : ticks+end])
+				b.WriteString("`" + Escape(strings.Replace(code, "`", "'", -1)) + "`")
+				i += 2*ticks + end
+				continue
+			}
+
+		case rest[0] == '<':
+			if m := autolinkRe.FindStringSubmatch(rest); m != nil {
+				b.WriteString(Link(m[1], ""))
+				i += len(m[0])
+				continue
+			}
+
+		case rest[0] == '[' || strings.HasPrefix(rest, "!["):
+			if m := mdLinkRe.FindStringSubmatch(rest); m != nil {
+				label := PlainText(Parse(convertInline(m[1])))
+				b.WriteString(Link(m[2], label))
+				i += len(m[0])
+				continue
+			}
+
+		case strings.HasPrefix(rest, "***") || strings.HasPrefix(rest, "___"):
+			if inner, n, ok := emphasis(s, i, rest[:3]); ok {
+				b.WriteString("*_" + convertInline(inner) + "_*")
+				i += n
+				continue
+			}
+			fallthrough
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if inner, n, ok := emphasis(s, i, rest[:2]); ok {
+				b.WriteString("*" + convertInline(inner) + "*")
+				i += n
+				continue
+			}
+
+		case strings.HasPrefix(rest, "~~"):
+			if inner, n, ok := emphasis(s, i, "~~"); ok {
+				b.WriteString("~" + convertInline(inner) + "~")
+				i += n
+				continue
+			}
+
+		case rest[0] == '*' || rest[0] == '_':
+			if inner, n, ok := emphasis(s, i, rest[:1]); ok {
+				b.WriteString("_" + convertInline(inner) + "_")
+				i += n
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(Escape(rest[:size]))
+		i += size
+	}
+	return b.String()
+}
+
+// emphasis - text between marker at s[i:] and closing marker. Like CommonMark, markers must be next to text
+// and _ inside words (snake_case) is not emphasis. Returns inner text and length with markers
+func emphasis(s string, i int, marker string) (string, int, bool) {
+	start := i + len(marker)
+	if start >= len(s) || isSpaceAt(s, start) {
+		return "", 0, false
+	}
+	if marker[0] == '_' && i > 0 && isWordBefore(s, i) {
+		return "", 0, false
+	}
+	for j := start + 1; j+len(marker) <= len(s); j++ {
+		if s[j:j+len(marker)] != marker || isSpaceBefore(s, j) {
+			continue
+		}
+		// Inside a longer run of markers (e.g. * of inner **bold**) - the run opened after a space
+		if s[j-1] == marker[0] {
+			continue
+		}
+		end := j + len(marker)
+		// Marker followed by the same character is a longer marker (e.g. * next to ** closes bold)
+		if end < len(s) && s[end] == marker[0] {
+			j++
+			continue
+		}
+		if marker[0] == '_' && end < len(s) && isWordAt(s, end) {
+			continue
+		}
+		return s[start:j], end - i, true
+	}
+	return "", 0, false
+}
+
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+func isSpaceBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsSpace(r)
+}
+
+func isWordAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
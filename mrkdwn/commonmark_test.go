@@ -0,0 +1,54 @@
+package mrkdwn
+
+import "testing"
+
+func TestFromMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		// emphasis
+		{"bold", "**bold** and __bold__", "*bold* and *bold*"},
+		{"italic", "*italic* and _italic_", "_italic_ and _italic_"},
+		{"bold italic", "***both***", "*_both_*"},
+		{"italic in bold", "**bold _italic_ bold**", "*bold _italic_ bold*"},
+		{"bold in italic", "*italic **bold** italic*", "_italic *bold* italic_"},
+		{"several spans", "*a* **b** *c*", "_a_ *b* _c_"},
+		{"strike", "~~gone~~", "~gone~"},
+		{"snake case is not italic", "snake_case_name", "snake_case_name"},
+		{"spaced stars are not italic", "2 * 3 * 4", "2 * 3 * 4"},
+		{"unclosed bold", "unclosed **bold", "unclosed **bold"},
+
+		// links
+		{"link", "[docs](https://example.com/a?b=1&c=2)", "<https://example.com/a?b=1&amp;c=2|docs>"},
+		{"link with title", `[docs](https://example.com "Docs")`, "<https://example.com|docs>"},
+		{"autolink", "see <https://example.com>", "see <https://example.com>"},
+		{"image as link", "![chart](http://x.com/a.png)", "<http://x.com/a.png|chart>"},
+		{"not a link", "[label] (text)", "[label] (text)"},
+
+		// code
+		{"code span escaped, not formatted", "`a <b> & **c**`", "`a &lt;b&gt; &amp; **c**`"},
+		{"code span with backtick", "``code with ` tick``", "`code with ' tick`"},
+		{"fenced code", "```go\nif a < b && c {\n**not bold**\n}\n```", "```\nif a &lt; b &amp;&amp; c {\n**not bold**\n}\n```"},
+		{"tilde fence", "~~~\nx\n~~~\nafter *it*", "```\nx\n```\nafter _it_"},
+		{"unclosed fence", "```\ncode", "```\ncode\n```"},
+
+		// lists and blocks
+		{"bullets", "- one\n* two\n+ three", "• one\n• two\n• three"},
+		{"nested bullets", "- one\n  - nested **b**", "• one\n  • nested *b*"},
+		{"numbered list kept", "1. first\n2. second", "1. first\n2. second"},
+		{"heading", "# Title **x**", "*Title x*"},
+		{"quote", "> quoted *it*", "> quoted _it_"},
+		{"rule", "---", "──────────"},
+		{"text escaped", "a < b & c > d", "a &lt; b &amp; c &gt; d"},
+		{"windows line ends", "**a**\r\n*b*", "*a*\n_b_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromMarkdown(tt.md); got != tt.want {
+				t.Errorf("FromMarkdown(%q) = %q, want %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package mrkdwn
+
+import (
+	"strings"
+
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+// User - user mention. Slack shows current name and notifies the user
+func User(id string) string {
+	return "<@" + id + ">"
+}
+
+// Channel - channel link
+func Channel(id string) string {
+	return "<#" + id + ">"
+}
+
+// UserGroup - user group mention. Slack notifies all members
+func UserGroup(id string) string {
+	return "<!subteam^" + id + ">"
+}
+
+// Special - special mention: here, channel or everyone
+func Special(name string) string {
+	return "<!" + name + ">"
+}
+
+// Link - link with optional label
+func Link(url string, label string) string {
+	if label == "" {
+		return "<" + Escape(url) + ">"
+	}
+	return "<" + Escape(url) + "|" + Escape(label) + ">"
+}
+
+// Mention - mention of directory entity. Unknown entities are shown by name
+func Mention(e utils.Entity) string {
+	switch e.Kind {
+	case utils.EntityUser:
+		return User(e.ID)
+	case utils.EntityChannel:
+		return Channel(e.ID)
+	case utils.EntityUserGroup:
+		return UserGroup(e.ID)
+	}
+	return Escape(e.Name)
+}
+
+// Escape - escape &, < and > so text is not taken for Slack control sequences
+func Escape(text string) string {
+	return escaper.Replace(text)
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Format - Slack text from tokens (reverse of Parse). Entities are written without labels,
+// so Slack shows current names
+func Format(tokens []Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		switch t.Kind {
+		case TokenUser:
+			b.WriteString(User(t.ID))
+		case TokenChannel:
+			b.WriteString(Channel(t.ID))
+		case TokenUserGroup:
+			b.WriteString(UserGroup(t.ID))
+		case TokenSpecial:
+			b.WriteString(Special(t.ID))
+		case TokenLink:
+			label := t.Text
+			if label == t.ID {
+				label = ""
+			}
+			b.WriteString(Link(t.ID, label))
+		default:
+			if t.Raw != "" {
+				b.WriteString(t.Raw)
+			} else {
+				b.WriteString(Escape(t.Text))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Quiet - Slack text with mentions that notify many people (@here, @channel, @everyone, user groups)
+// turned to plain text. Use it when reposting text written by someone else
+func Quiet(text string) string {
+	tokens := Parse(text)
+	for i, t := range tokens {
+		if t.Kind == TokenSpecial || t.Kind == TokenUserGroup {
+			tokens[i] = Token{Kind: TokenText, Text: t.String()}
+		}
+	}
+	return Format(tokens)
+}
@@ -0,0 +1,194 @@
+package mrkdwn
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+// TokenKind - kind of text fragment
+type TokenKind int
+
+// Token kinds
+const (
+	TokenText      TokenKind = iota // plain text
+	TokenUser                       // <@U123> or <@U123|bob>
+	TokenChannel                    // <#C123> or <#C123|general>
+	TokenUserGroup                  // <!subteam^S123> or <!subteam^S123|@team>
+	TokenSpecial                    // <!here>, <!channel>, <!everyone>, <!date^...>
+	TokenLink                       // <http://example.com|label>, <mailto:jan@example.com>
+)
+
+// Token - fragment of Slack message text
+type Token struct {
+	Kind TokenKind
+	// Raw - fragment as it was in Slack text
+	Raw string
+	// Text - unescaped text for TokenText. For entities label (name without @ or #), empty if Slack didn't send it
+	Text string
+	// ID - user, channel or user group ID, name of special mention (here, channel, everyone) or link URL
+	ID string
+}
+
+// String - human readable form: @bob, #general, @here, link label
+func (t Token) String() string {
+	label := t.Text
+	if label == "" {
+		label = t.ID
+	}
+	switch t.Kind {
+	case TokenUser, TokenUserGroup, TokenSpecial:
+		return "@" + label
+	case TokenChannel:
+		return "#" + label
+	case TokenLink:
+		return strings.TrimPrefix(label, "mailto:")
+	}
+	return t.Text
+}
+
+// Entity - directory entity of user, channel or user group token. Kind is EntityUnknown for other tokens
+func (t Token) Entity() utils.Entity {
+	switch t.Kind {
+	case TokenUser:
+		return utils.Entity{Kind: utils.EntityUser, ID: t.ID, Name: t.Text}
+	case TokenChannel:
+		return utils.Entity{Kind: utils.EntityChannel, ID: t.ID, Name: t.Text}
+	case TokenUserGroup:
+		return utils.Entity{Kind: utils.EntityUserGroup, ID: t.ID, Name: t.Text}
+	}
+	return utils.Entity{Name: t.Text}
+}
+
+// Parse - split Slack message text into plain text and entity tokens
+func Parse(text string) []Token {
+	var tokens []Token
+	for text != "" {
+		start := strings.Index(text, "<")
+		if start < 0 {
+			tokens = append(tokens, textToken(text))
+			break
+		}
+		end := strings.Index(text[start:], ">")
+		if end < 0 {
+			// Not an entity - Slack escapes < in user text
+			tokens = append(tokens, textToken(text))
+			break
+		}
+		end += start
+
+		if start > 0 {
+			tokens = append(tokens, textToken(text[:start]))
+		}
+		tokens = append(tokens, entityToken(text[start:end+1]))
+		text = text[end+1:]
+	}
+	return tokens
+}
+
+func textToken(raw string) Token {
+	return Token{Kind: TokenText, Raw: raw, Text: Unescape(raw)}
+}
+
+// entityToken - token from <...> fragment
+func entityToken(raw string) Token {
+	inner := raw[1 : len(raw)-1]
+	label := ""
+	if i := strings.Index(inner, "|"); i >= 0 {
+		inner, label = inner[:i], Unescape(inner[i+1:])
+	}
+
+	t := Token{Raw: raw, Text: label}
+	switch {
+	case strings.HasPrefix(inner, "@"):
+		t.Kind, t.ID, t.Text = TokenUser, inner[1:], strings.TrimPrefix(label, "@")
+	case strings.HasPrefix(inner, "#"):
+		t.Kind, t.ID, t.Text = TokenChannel, inner[1:], strings.TrimPrefix(label, "#")
+	case strings.HasPrefix(inner, "!subteam^"):
+		t.Kind, t.ID, t.Text = TokenUserGroup, strings.TrimPrefix(inner, "!subteam^"), strings.TrimPrefix(label, "@")
+	case strings.HasPrefix(inner, "!"):
+		t.Kind, t.ID = TokenSpecial, inner[1:]
+		if strings.HasPrefix(t.ID, "date^") {
+			// <!date^1392734382^{date_short}|Feb 18, 2014> - label is the date as text
+			t.Kind = TokenText
+			if t.Text == "" {
+				t.Text = t.ID
+			}
+		}
+	default:
+		t.Kind, t.ID = TokenLink, Unescape(inner)
+	}
+	return t
+}
+
+// Unescape - replace Slack escapes (&amp; &lt; &gt;) with characters
+func Unescape(text string) string {
+	return unescaper.Replace(text)
+}
+
+var unescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+// Resolve - fill missing names of user, channel and user group tokens from directory.
+// Tokens not found in directory keep empty name and String shows their ID
+func Resolve(tokens []Token, dir utils.Directory) {
+	for i := range tokens {
+		t := &tokens[i]
+		if t.Text != "" {
+			continue
+		}
+		var name string
+		var err error
+		switch t.Kind {
+		case TokenUser:
+			name, err = dir.UserName(t.ID)
+		case TokenChannel:
+			name, err = dir.ChannelName(t.ID)
+		case TokenUserGroup:
+			name, err = dir.UserGroupName(t.ID)
+		default:
+			continue
+		}
+		if err == nil {
+			t.Text = name
+		}
+	}
+}
+
+// PlainText - human readable text: entities as @name and #channel, escapes removed
+func PlainText(tokens []Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+// Fields - split command text into words like strings.Fields. Entities are single fields in Slack form
+// (<@U123|bob> can be given to Directory.Lookup), plain words are unescaped
+func Fields(text string) []string {
+	var fields []string
+	current := ""
+	flush := func() {
+		if current != "" {
+			fields = append(fields, current)
+			current = ""
+		}
+	}
+
+	for _, t := range Parse(text) {
+		if t.Kind != TokenText {
+			current += t.Raw
+			continue
+		}
+		for _, r := range t.Text {
+			if unicode.IsSpace(r) {
+				flush()
+				continue
+			}
+			current += string(r)
+		}
+	}
+	flush()
+	return fields
+}
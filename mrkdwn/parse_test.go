@@ -0,0 +1,109 @@
+package mrkdwn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Token
+	}{
+		{"escaped brackets are text", "a &lt;b&gt; &amp; c", []Token{
+			{Kind: TokenText, Raw: "a &lt;b&gt; &amp; c", Text: "a <b> & c"},
+		}},
+		{"user with label", "hi <@U123|bob>!", []Token{
+			{Kind: TokenText, Raw: "hi ", Text: "hi "},
+			{Kind: TokenUser, Raw: "<@U123|bob>", Text: "bob", ID: "U123"},
+			{Kind: TokenText, Raw: "!", Text: "!"},
+		}},
+		{"user without label", "<@U123>", []Token{
+			{Kind: TokenUser, Raw: "<@U123>", ID: "U123"},
+		}},
+		{"user group", "<!subteam^S123|@h>", []Token{
+			{Kind: TokenUserGroup, Raw: "<!subteam^S123|@h>", Text: "h", ID: "S123"},
+		}},
+		{"channel", "<#C123|general>", []Token{
+			{Kind: TokenChannel, Raw: "<#C123|general>", Text: "general", ID: "C123"},
+		}},
+		{"special mention", "<!here>", []Token{
+			{Kind: TokenSpecial, Raw: "<!here>", ID: "here"},
+		}},
+		{"link with escaped URL", "<http://x.com/?a=1&amp;b=2|site &amp; co>", []Token{
+			{Kind: TokenLink, Raw: "<http://x.com/?a=1&amp;b=2|site &amp; co>", Text: "site & co", ID: "http://x.com/?a=1&b=2"},
+		}},
+		{"date is text", "<!date^1392734382^{date_short}|Feb 18, 2014>", []Token{
+			{Kind: TokenText, Raw: "<!date^1392734382^{date_short}|Feb 18, 2014>", Text: "Feb 18, 2014", ID: "date^1392734382^{date_short}"},
+		}},
+		{"unclosed bracket", "a <b", []Token{
+			{Kind: TokenText, Raw: "a <b", Text: "a <b"},
+		}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) =\n%+v\nwant\n%+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"hi <@U123|bob> in <#C123|general>", "hi @bob in #general"},
+		{"&lt;@U123&gt; is not a mention", "<@U123> is not a mention"},
+		{"<!subteam^S123|@h> ping", "@h ping"},
+		{"<@U123>", "@U123"},
+		{"<!here> &amp; <!channel>", "@here & @channel"},
+		{"<mailto:jan@example.com>", "jan@example.com"},
+		{"<http://example.com|docs>", "docs"},
+	}
+	for _, tt := range tests {
+		if got := PlainText(Parse(tt.text)); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"add <@U123|bob>  <!subteam^S123|@h>", []string{"add", "<@U123|bob>", "<!subteam^S123|@h>"}},
+		{"x&lt;y &gt;z", []string{"x<y", ">z"}},
+		{"<@U123|jan kowalski> admin", []string{"<@U123|jan kowalski>", "admin"}},
+		{"pre<@U123>post", []string{"pre<@U123>post"}},
+		{"\t \n", nil},
+	}
+	for _, tt := range tests {
+		if got := Fields(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Fields(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"<!channel> deploy done", "@channel deploy done"},
+		{"<!here|here> and <!everyone>", "@here and @everyone"},
+		{"<!subteam^S123|@ops> look", "@ops look"},
+		{"ask <@U123|bob> in <#C123|general>", "ask <@U123> in <#C123>"},
+		{"a &lt;b&gt; &amp; <http://x.com|site>", "a &lt;b&gt; &amp; <http://x.com|site>"},
+		{"<!date^1392734382^{date_short}|Feb 18, 2014>", "<!date^1392734382^{date_short}|Feb 18, 2014>"},
+	}
+	for _, tt := range tests {
+		if got := Quiet(tt.text); got != tt.want {
+			t.Errorf("Quiet(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package mrkdwn
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Date formats - Slack shows the date in the timezone and locale of the reader
+const (
+	DateNum         = "{date_num}"          // 2014-02-18
+	DateShort       = "{date_short}"        // Feb 18, 2014
+	DateLong        = "{date_long}"         // Tuesday, February 18th, 2014
+	DateShortPretty = "{date_short_pretty}" // today, yesterday or Feb 18, 2014
+	DateLongPretty  = "{date_long_pretty}"  // today, yesterday or Tuesday, February 18th, 2014
+	Time            = "{time}"              // 6:39 AM
+	TimeSecs        = "{time_secs}"         // 6:39:42 AM
+)
+
+// Bold - *text*. Text is escaped
+func Bold(text string) string {
+	return wrap("*", Escape(text))
+}
+
+// Italic - _text_. Text is escaped
+func Italic(text string) string {
+	return wrap("_", Escape(text))
+}
+
+// Strike - ~text~. Text is escaped
+func Strike(text string) string {
+	return wrap("~", Escape(text))
+}
+
+// Code - `text`. Text is escaped, backticks are replaced because Slack has no escape for them
+func Code(text string) string {
+	text = strings.Replace(text, "`", "'", -1)
+	if strings.TrimSpace(text) == "" {
+		return Escape(text)
+	}
+	return "`" + Escape(text) + "`"
+}
+
+// CodeBlock - preformatted ```text```. Text is escaped
+func CodeBlock(text string) string {
+	return "```\n" + Escape(strings.Replace(text, "```", "'''", -1)) + "\n```"
+}
+
+// Quote - block quote, every line starts with >. Text is escaped
+func Quote(text string) string {
+	lines := strings.Split(Escape(text), "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Date - time shown in reader's timezone, e.g. Date(t, DateShort+" "+Time).
+// Clients which can't show it get UTC time
+func Date(t time.Time, format string) string {
+	fallback := t.UTC().Format("2006-01-02 15:04 UTC")
+	return "<!date^" + strconv.FormatInt(t.Unix(), 10) + "^" + Escape(format) + "|" + Escape(fallback) + ">"
+}
+
+// DateLink - Date linked to url
+func DateLink(t time.Time, format string, url string) string {
+	fallback := t.UTC().Format("2006-01-02 15:04 UTC")
+	return "<!date^" + strconv.FormatInt(t.Unix(), 10) + "^" + Escape(format) + "^" + Escape(url) + "|" + Escape(fallback) + ">"
+}
+
+// wrap - put marker around every non-empty line of text. Slack needs markers next to words,
+// so leading and trailing spaces are moved outside
+func wrap(marker string, text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimFunc(line, unicode.IsSpace)
+		if trimmed == "" {
+			continue
+		}
+		start := strings.Index(line, trimmed)
+		lines[i] = line[:start] + marker + trimmed + marker + line[start+len(trimmed):]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,159 @@
+package robots
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	// Slack shows at most 5 actions per attachment and recommends at most 20 attachments
+	maxAttachments       = 20
+	maxAttachmentActions = 5
+)
+
+// Attachment colors
+const (
+	ColorGood    = "good"
+	ColorWarning = "warning"
+	ColorDanger  = "danger"
+)
+
+// Attachment - secondary message content (legacy attachments). Prefer Blocks for new layouts
+type Attachment struct {
+	Fallback   string            `json:"fallback"`
+	Color      string            `json:"color,omitempty"`
+	Pretext    string            `json:"pretext,omitempty"`
+	AuthorName string            `json:"author_name,omitempty"`
+	AuthorLink string            `json:"author_link,omitempty"`
+	AuthorIcon string            `json:"author_icon,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	TitleLink  string            `json:"title_link,omitempty"`
+	Text       string            `json:"text,omitempty"`
+	Fields     []AttachmentField `json:"fields,omitempty"`
+	ImageURL   string            `json:"image_url,omitempty"`
+	ThumbURL   string            `json:"thumb_url,omitempty"`
+	Footer     string            `json:"footer,omitempty"`
+	FooterIcon string            `json:"footer_icon,omitempty"`
+	// Timestamp - time shown in footer (Unix seconds)
+	Timestamp  int64              `json:"ts,omitempty"`
+	CallbackID string             `json:"callback_id,omitempty"`
+	Actions    []AttachmentAction `json:"actions,omitempty"`
+	MarkdownIn []MarkdownField    `json:"mrkdwn_in,omitempty"`
+}
+
+type MarkdownField string
+
+var (
+	MarkdownFieldPretext  = MarkdownField("pretext")
+	MarkdownFieldText     = MarkdownField("text")
+	MarkdownFieldTitle    = MarkdownField("title")
+	MarkdownFieldFields   = MarkdownField("fields")
+	MarkdownFieldFallback = MarkdownField("fallback")
+)
+
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+// AttachmentAction - button or menu of an attachment. Buttons with URL open the link,
+// other actions are sent to the app with attachment CallbackID
+type AttachmentAction struct {
+	Name    string              `json:"name,omitempty"`
+	Text    string              `json:"text"`
+	Type    string              `json:"type"`
+	Value   string              `json:"value,omitempty"`
+	URL     string              `json:"url,omitempty"`
+	Style   string              `json:"style,omitempty"`
+	Confirm *ActionConfirmation `json:"confirm,omitempty"`
+}
+
+// ActionConfirmation - dialog shown before action is sent
+type ActionConfirmation struct {
+	Title       string `json:"title,omitempty"`
+	Text        string `json:"text"`
+	OkText      string `json:"ok_text,omitempty"`
+	DismissText string `json:"dismiss_text,omitempty"`
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// SetTime - show time t in attachment footer
+func (a *Attachment) SetTime(t time.Time) {
+	a.Timestamp = t.Unix()
+}
+
+// Validate - check fallback, color, URLs and actions. Slack silently drops invalid values
+func (a Attachment) Validate() error {
+	if a.withFallback().Fallback == "" {
+		return fmt.Errorf("fallback required (shown in notifications) - set it or title, text, pretext or author name")
+	}
+	if a.Color != "" && a.Color != ColorGood && a.Color != ColorWarning && a.Color != ColorDanger && !hexColor.MatchString(a.Color) {
+		return fmt.Errorf("invalid color '%s' (use good, warning, danger or #RRGGBB)", a.Color)
+	}
+	urls := []struct{ name, value string }{
+		{"author_link", a.AuthorLink},
+		{"author_icon", a.AuthorIcon},
+		{"title_link", a.TitleLink},
+		{"image_url", a.ImageURL},
+		{"thumb_url", a.ThumbURL},
+		{"footer_icon", a.FooterIcon},
+	}
+	for _, u := range urls {
+		if err := checkURL(u.value); err != nil {
+			return fmt.Errorf("%s: %v", u.name, err)
+		}
+	}
+
+	if len(a.Actions) > maxAttachmentActions {
+		return fmt.Errorf("%d actions, max %d", len(a.Actions), maxAttachmentActions)
+	}
+	for i, action := range a.Actions {
+		if action.Text == "" || action.Type == "" {
+			return fmt.Errorf("action %d: text and type required", i+1)
+		}
+		if err := checkURL(action.URL); err != nil {
+			return fmt.Errorf("action %d url: %v", i+1, err)
+		}
+		if action.URL == "" && a.CallbackID == "" {
+			return fmt.Errorf("action %d: callback_id required for actions without URL", i+1)
+		}
+		if action.Style != ButtonDefault && action.Style != ButtonPrimary && action.Style != ButtonDanger {
+			return fmt.Errorf("action %d: unknown style '%s'", i+1, action.Style)
+		}
+	}
+	return nil
+}
+
+// withFallback - attachment with fallback made from title, text or pretext if not set.
+// Fallback is required and shown in notifications
+func (a Attachment) withFallback() Attachment {
+	if a.Fallback != "" {
+		return a
+	}
+	for _, s := range []string{a.Title, a.Text, a.Pretext, a.AuthorName} {
+		if s != "" {
+			a.Fallback = s
+			break
+		}
+	}
+	return a
+}
+
+// checkURL - empty or absolute http(s) URL
+func checkURL(u string) error {
+	if u == "" {
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("'%s' is not absolute http(s) URL", u)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package robots
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// golden - compare JSON with testdata/name.json (rewritten with -update)
+func golden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name+".json")
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestAttachmentJSON(t *testing.T) {
+	full := Attachment{
+		Fallback:   "Deploy finished",
+		Color:      "#36a64f",
+		Pretext:    "*Deploy* report",
+		AuthorName: "ci",
+		AuthorLink: "https://ci.example.com",
+		Title:      "Build 42",
+		TitleLink:  "https://ci.example.com/42",
+		Text:       "All _green_",
+		Fields: []AttachmentField{
+			{Title: "Env", Value: "prod", Short: true},
+			{Title: "Changes", Value: "• fix login\n• faster search"},
+		},
+		Footer:     "deploybot",
+		CallbackID: "deploy_42",
+		Actions: []AttachmentAction{
+			{Text: "Logs", Type: "button", URL: "https://ci.example.com/42/logs"},
+			{Name: "rollback", Text: "Rollback", Type: "button", Value: "42", Style: ButtonDanger,
+				Confirm: &ActionConfirmation{Title: "Sure?", Text: "Rollback build 42", OkText: "Yes", DismissText: "No"}},
+		},
+		MarkdownIn: []MarkdownField{MarkdownFieldPretext, MarkdownFieldText, MarkdownFieldFields},
+	}
+	full.SetTime(time.Unix(1500000000, 0))
+
+	tests := []struct {
+		name string
+		m    Message
+	}{
+		{"attachment_full", Message{Channel: "C0123ABCD", Text: "Deploy", Attachments: []Attachment{full}}},
+		// fallback is made from title, empty fields are omitted
+		{"attachment_minimal", Message{Channel: "C0123ABCD", Attachments: []Attachment{{Title: "Only title", Color: ColorWarning}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.m.prepare()
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			golden(t, tt.name, m)
+		})
+	}
+}
+
+func TestAttachmentValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Attachment
+		err  string // part of error, empty if valid
+	}{
+		{"valid named color", Attachment{Text: "x", Color: ColorDanger}, ""},
+		{"valid short hex color", Attachment{Text: "x", Color: "#f00"}, ""},
+		{"bad color name", Attachment{Text: "x", Color: "red"}, "invalid color"},
+		{"bad hex color", Attachment{Text: "x", Color: "#12345"}, "invalid color"},
+		{"relative URL", Attachment{Text: "x", TitleLink: "/builds/42"}, "title_link"},
+		{"not http URL", Attachment{Text: "x", ImageURL: "ftp://example.com/a.png"}, "image_url"},
+		{"bad action URL", Attachment{Text: "x", Actions: []AttachmentAction{{Text: "Go", Type: "button", URL: "example.com"}}}, "action 1 url"},
+		{"missing fallback", Attachment{ImageURL: "https://example.com/a.png"}, "fallback required"},
+		{"fallback from pretext", Attachment{Pretext: "x"}, ""},
+		{"action without callback", Attachment{Text: "x", Actions: []AttachmentAction{{Text: "Go", Type: "button"}}}, "callback_id required"},
+		{"unknown action style", Attachment{Text: "x", CallbackID: "c", Actions: []AttachmentAction{{Text: "Go", Type: "button", Style: "red"}}}, "unknown style"},
+		{"too many actions", Attachment{Text: "x", CallbackID: "c", Actions: make([]AttachmentAction, maxAttachmentActions+1)}, "actions, max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.a.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate() = %v, want error with %q", err, tt.err)
+			}
+		})
+	}
+}
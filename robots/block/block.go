@@ -2,19 +2,24 @@ package robots
 
 import (
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/wojtekzw/slackbot/mrkdwn"
 	"github.com/wojtekzw/slackbot/robots"
 	"github.com/wojtekzw/slackbot/rtm"
 )
 
 type bot struct {
-	config *rtm.BlockConfig
+	config    *rtm.BlockConfig
+	retention *rtm.RetentionConfig
 }
 
 // TODO - nie używać zmiennej globalne rtm.Config - tylko jak ?
 func init() {
-	r := &bot{config: rtm.Config}
+	r := &bot{config: rtm.Config, retention: rtm.Retention}
 
 	robots.RegisterRobot("block", r)
 }
@@ -40,34 +45,259 @@ func (r bot) DeferredAction(p *robots.Payload) {
 }
 
 func (r bot) Description() (description string) {
-	return "Block write to a channel\n\tUsage: /block add|remove|list|refresh|help"
+	return "Block write to a channel\n\tUsage: /block add <user>|remove <user>|list|refresh|scan <since>|bots|allowbot <entry>|removebot <entry>|slow [#channel <limit> <window>|off]|retention [report]|help"
 }
 
 func (r bot) listCommand() string {
-	adminsStr := strings.Join(r.config.AdminNames(), ",")
-	allowedUsersStr := strings.Join(r.config.AllowedUsersNames(), ", ")
+	adminsStr := mrkdwn.Escape(strings.Join(r.config.AdminNames(), ","))
+	allowedUsersStr := mrkdwn.Escape(strings.Join(r.config.AllowedUsersNames(), ", "))
 
-	return fmt.Sprintf("Blocked channel: %s\nAdmins: %s\nAllowed users: %s\n", r.config.Channel.Name, adminsStr, allowedUsersStr)
+	var botsList []string
+	for _, e := range r.config.BotEntries() {
+		botsList = append(botsList, mrkdwn.Code(e.String()))
+	}
+
+	return fmt.Sprintf("%s %s\n%s %s\n%s %s\n%s %s\n", mrkdwn.Bold("Blocked channel:"), channelLink(r.config.BlockedChannel()),
+		mrkdwn.Bold("Admins:"), adminsStr, mrkdwn.Bold("Allowed users:"), allowedUsersStr, mrkdwn.Bold("Allowed bots:"), strings.Join(botsList, ", "))
+}
+
+func (r bot) isAdmin(p *robots.Payload) bool {
+	return r.config.IsOwner(p.UserID) || r.config.IsAdmin(p.UserID)
+}
+
+// scanCommand - moderate blocked channel history since given time. Scan can be long so result is sent to response URL
+func (r bot) scanCommand(p *robots.Payload, args []string) string {
+	if !r.isAdmin(p) {
+		return "scan: Only owner or admins can scan the channel"
+	}
+
+	since := ""
+	if len(args) > 0 {
+		since = args[0]
+	}
+	oldest, err := r.config.ParseSince(since)
+	if err != nil {
+		return "scan: " + mrkdwn.Escape(err.Error())
+	}
+
+	// Progress message is replaced with the result when scan is done
+	go func() {
+		progress := robots.SlashCommandResponse{Text: fmt.Sprintf("scan: Scanning %s since %s...", channelLink(r.config.BlockedChannel()), tsDate(oldest))}.Send(p)
+		result := robots.Message{}
+		scanned, deleted, err := r.config.ScanHistory(oldest)
+		if err != nil {
+			result.Text = fmt.Sprintf("scan: Error scanning %s: %s", channelLink(r.config.BlockedChannel()), mrkdwn.Escape(err.Error()))
+		} else {
+			result.Text = fmt.Sprintf("scan: %s - scanned %d messages, deleted %d", channelLink(r.config.BlockedChannel()), scanned, deleted)
+		}
+		if err := progress.Update(result).Wait(); err != nil {
+			log.Printf("Error sending scan result: %v", err)
+		}
+	}()
+	return ""
+}
+
+// botsCommand - list bots which posted recently so admins can allow-list them
+func (r bot) botsCommand() string {
+	recent := r.config.RecentBots()
+	if len(recent) == 0 {
+		return "bots: No bots posted recently"
+	}
+
+	lines := []string{mrkdwn.Bold("Bots which posted recently:")}
+	for _, b := range recent {
+		status := "blocked"
+		if r.config.IsAllowedBot(b.BotID, b.AppID, b.Username) {
+			status = "allowed"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s - %d messages, last %s [%s]",
+			mrkdwn.Code("bot:"+b.BotID), mrkdwn.Code("app:"+b.AppID), mrkdwn.Code("name:"+b.Username), b.Messages,
+			mrkdwn.Date(b.LastSeen, mrkdwn.DateNum+" "+mrkdwn.Time), status))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addCommand - add or remove user or user group allowed to write to the blocked channel.
+// User can be given as @name, display or real name, email or mention, user group as @handle
+func (r bot) addCommand(p *robots.Payload, args []string, add bool) string {
+	cmd := "remove"
+	if add {
+		cmd = "add"
+	}
+	if !r.isAdmin(p) {
+		return cmd + ": Only owner or admins can change allowed users and groups"
+	}
+	if len(args) == 0 {
+		return "Usage: /block add|remove @user|@group (display name, real name or email work too)"
+	}
+
+	// Real and display names can have spaces
+	ref := strings.Join(args, " ")
+	var user rtm.NameID
+	var err error
+	if add {
+		user, err = r.config.AddAllowedUser(ref)
+	} else {
+		user, err = r.config.RemoveAllowedUser(ref)
+	}
+	if err != nil {
+		return cmd + ": " + mrkdwn.Escape(err.Error())
+	}
+	if add {
+		return fmt.Sprintf("add: %s allowed to write to %s", mrkdwn.Bold(user.Name), channelLink(r.config.BlockedChannel()))
+	}
+	return fmt.Sprintf("remove: %s can't write to %s any more", mrkdwn.Bold(user.Name), channelLink(r.config.BlockedChannel()))
+}
+
+func (r bot) allowBotCommand(p *robots.Payload, args []string, allow bool) string {
+	if !r.isAdmin(p) {
+		return "Only owner or admins can change allowed bots"
+	}
+	if len(args) != 1 {
+		return "Usage: /block allowbot|removebot bot:B0123|app:A0123|name:username"
+	}
+	entry, err := rtm.ParseBotEntry(args[0])
+	if err != nil {
+		return mrkdwn.Escape(err.Error())
+	}
+	if allow {
+		r.config.AllowBot(entry)
+		return fmt.Sprintf("allowbot: %s allowed to write to %s", mrkdwn.Code(entry.String()), channelLink(r.config.BlockedChannel()))
+	}
+	if !r.config.RemoveBot(entry) {
+		return fmt.Sprintf("removebot: %s not found (bots configured in environment can't be removed)", mrkdwn.Code(entry.String()))
+	}
+	return fmt.Sprintf("removebot: %s removed", mrkdwn.Code(entry.String()))
+}
+
+// slowCommand - list slow mode channels or set posting limit of a channel, e.g. /block slow #general 3 10m
+func (r bot) slowCommand(p *robots.Payload, args []string) string {
+	if len(args) == 0 {
+		channels := r.config.SlowModeChannels()
+		if len(channels) == 0 {
+			return "slow: No channels in slow mode"
+		}
+		lines := []string{mrkdwn.Bold("Channels in slow mode:")}
+		for _, id := range channels {
+			mode, _ := r.config.SlowModeFor(id)
+			lines = append(lines, fmt.Sprintf("%s - %s", mrkdwn.Channel(id), mode))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if !r.isAdmin(p) {
+		return "slow: Only owner or admins can change slow mode"
+	}
+
+	channelID := r.config.ChannelID(args[0])
+	if channelID == "" {
+		return fmt.Sprintf("slow: Unknown channel %s", mrkdwn.Escape(args[0]))
+	}
+
+	if len(args) == 2 && args[1] == "off" {
+		r.config.SetSlowMode(channelID, rtm.SlowMode{})
+		return fmt.Sprintf("slow: Slow mode off in %s", mrkdwn.Channel(channelID))
+	}
+	if len(args) != 3 {
+		return "Usage: /block slow #channel <limit> <window> (e.g. 3 10m) or /block slow #channel off"
+	}
+
+	mode, err := rtm.ParseSlowMode(args[1], args[2])
+	if err != nil {
+		return "slow: " + mrkdwn.Escape(err.Error())
+	}
+	r.config.SetSlowMode(channelID, mode)
+	return fmt.Sprintf("slow: %s limited to %s per user", mrkdwn.Channel(channelID), mode)
+}
+
+// retentionCommand - list retention policies or report (dry-run) what the next pass would delete
+func (r bot) retentionCommand(p *robots.Payload, args []string) string {
+	if len(args) == 0 {
+		if len(r.retention.Policies) == 0 {
+			return "retention: No retention policies (set SLACKBOT_RETENTION)"
+		}
+		lines := []string{mrkdwn.Bold("Retention policies") + fmt.Sprintf(" (every %s, keep pinned: %t, keep threads: %t, keep reactions: %s):",
+			r.retention.Interval, r.retention.KeepPinned, r.retention.KeepThreads, strings.Join(r.retention.KeepReactions, ", "))}
+		for _, policy := range r.retention.Policies {
+			lines = append(lines, fmt.Sprintf("%s - %d day(s)", channelLink(policy.Channel), int(policy.MaxAge.Hours()/24)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if args[0] != "report" {
+		return "Usage: /block retention [report]"
+	}
+	if !r.isAdmin(p) {
+		return "retention: Only owner or admins can run retention report"
+	}
+
+	go func() {
+		progress := robots.SlashCommandResponse{Text: "retention: Preparing report..."}.Send(p)
+		lines := []string{mrkdwn.Bold("Retention report") + " (dry-run, nothing deleted):"}
+		for _, res := range r.retention.Apply(true) {
+			lines = append(lines, res.String())
+		}
+		if err := progress.Update(robots.Message{Text: strings.Join(lines, "\n")}).Wait(); err != nil {
+			log.Printf("Error sending retention report: %v", err)
+		}
+	}()
+	return ""
 }
 
 func (r bot) blockCommand(p *robots.Payload) (result string) {
-	inText := strings.ToLower(strings.TrimSpace(p.Text))
+	// Keep mentions like <@U123|bob> as single arguments
+	args := mrkdwn.Fields(p.Text)
+	inText := ""
+	if len(args) > 0 {
+		inText = strings.ToLower(args[0])
+		args = args[1:]
+	}
 	outText := ""
 
 	switch inText {
 	case "add":
-		outText = inText + ": Add command"
+		outText = r.addCommand(p, args, true)
 	case "remove":
-		outText = inText + ": Remove command"
+		outText = r.addCommand(p, args, false)
 	case "list":
 		outText = r.listCommand()
 	case "refresh":
-		outText = inText + ": Refresh command"
+		r.config.RequestRefresh()
+		outText = inText + ": Users and channels refresh requested"
+	case "scan":
+		outText = r.scanCommand(p, args)
+	case "bots":
+		outText = r.botsCommand()
+	case "allowbot":
+		outText = r.allowBotCommand(p, args, true)
+	case "removebot":
+		outText = r.allowBotCommand(p, args, false)
+	case "slow":
+		outText = r.slowCommand(p, args)
+	case "retention":
+		outText = r.retentionCommand(p, args)
 	case "help":
 		outText = r.Description()
 
 	default:
-		outText = inText + ": Unknown command"
+		outText = mrkdwn.Escape(inText) + ": Unknown command"
 	}
 	return outText
 }
+
+// channelLink - channel link, or name if the channel was not found in directory
+func channelLink(c rtm.NameID) string {
+	if c.ID == "" {
+		return mrkdwn.Escape("#" + c.Name)
+	}
+	return mrkdwn.Channel(c.ID)
+}
+
+// tsDate - Slack timestamp as date shown in reader's timezone
+func tsDate(ts string) string {
+	sec, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return mrkdwn.Escape(ts)
+	}
+	return mrkdwn.Date(time.Unix(int64(sec), 0), mrkdwn.DateShort+" "+mrkdwn.Time)
+}
@@ -0,0 +1,578 @@
+package robots
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Slack Block Kit limits
+const (
+	maxBlocks              = 50
+	maxBlockIDLen          = 255
+	maxSectionTextLen      = 3000
+	maxSectionFields       = 10
+	maxSectionFieldLen     = 2000
+	maxContextElements     = 10
+	maxActionsElements     = 25
+	maxImageURLLen         = 3000
+	maxAltTextLen          = 2000
+	maxLabelLen            = 2000
+	maxButtonTextLen       = 75
+	maxButtonValueLen      = 2000
+	maxActionIDLen         = 255
+	maxSelectOptions       = 100
+	maxOptionTextLen       = 75
+	maxOptionValueLen      = 75
+	maxPlaceholderLen      = 150
+	maxInputInitialValueLn = 3000
+)
+
+// Text object types
+const (
+	TextPlain    = "plain_text"
+	TextMarkdown = "mrkdwn"
+)
+
+// Button styles
+const (
+	ButtonDefault = ""
+	ButtonPrimary = "primary"
+	ButtonDanger  = "danger"
+)
+
+// Block - Block Kit layout block
+type Block interface {
+	// BlockType - type of block in Slack JSON (section, context...)
+	BlockType() string
+	validate() error
+	// fallback - plain text of the block for notifications and clients without blocks
+	fallback() string
+}
+
+// BlockElement - interactive or image element of a block
+type BlockElement interface {
+	ElementType() string
+	validate() error
+}
+
+// TextObject - plain_text or mrkdwn text
+type TextObject struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Emoji    bool   `json:"emoji,omitempty"`
+	Verbatim bool   `json:"verbatim,omitempty"`
+}
+
+// PlainText - plain_text object
+func PlainText(text string) *TextObject {
+	return &TextObject{Type: TextPlain, Text: text, Emoji: true}
+}
+
+// MarkdownText - mrkdwn text object
+func MarkdownText(text string) *TextObject {
+	return &TextObject{Type: TextMarkdown, Text: text}
+}
+
+// ElementType - text objects can be context block elements
+func (t *TextObject) ElementType() string {
+	return t.Type
+}
+
+func (t *TextObject) validate() error {
+	if t.Type != TextPlain && t.Type != TextMarkdown {
+		return fmt.Errorf("unknown text type '%s'", t.Type)
+	}
+	if t.Text == "" {
+		return fmt.Errorf("empty text")
+	}
+	return nil
+}
+
+// OptionObject - option of a select element
+type OptionObject struct {
+	Text  *TextObject `json:"text"`
+	Value string      `json:"value"`
+}
+
+// SectionBlock - text with optional fields (two columns) and accessory element
+type SectionBlock struct {
+	BlockID   string        `json:"block_id,omitempty"`
+	Text      *TextObject   `json:"text,omitempty"`
+	Fields    []*TextObject `json:"fields,omitempty"`
+	Accessory BlockElement  `json:"accessory,omitempty"`
+}
+
+// ContextBlock - small text and images
+type ContextBlock struct {
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []BlockElement `json:"elements"`
+}
+
+// DividerBlock - horizontal line
+type DividerBlock struct {
+	BlockID string `json:"block_id,omitempty"`
+}
+
+// ActionsBlock - interactive elements (buttons, selects...)
+type ActionsBlock struct {
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []BlockElement `json:"elements"`
+}
+
+// ImageBlock - image with optional title
+type ImageBlock struct {
+	BlockID  string      `json:"block_id,omitempty"`
+	ImageURL string      `json:"image_url"`
+	AltText  string      `json:"alt_text"`
+	Title    *TextObject `json:"title,omitempty"`
+}
+
+// InputBlock - input element with label (modals and messages)
+type InputBlock struct {
+	BlockID  string       `json:"block_id,omitempty"`
+	Label    *TextObject  `json:"label"`
+	Element  BlockElement `json:"element"`
+	Hint     *TextObject  `json:"hint,omitempty"`
+	Optional bool         `json:"optional,omitempty"`
+}
+
+// ButtonElement - button with URL or value sent to the app
+type ButtonElement struct {
+	Text     *TextObject `json:"text"`
+	ActionID string      `json:"action_id,omitempty"`
+	URL      string      `json:"url,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	Style    string      `json:"style,omitempty"`
+}
+
+// ImageElement - small image in section accessory or context block
+type ImageElement struct {
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// StaticSelectElement - select menu with fixed options
+type StaticSelectElement struct {
+	Placeholder *TextObject     `json:"placeholder"`
+	ActionID    string          `json:"action_id,omitempty"`
+	Options     []*OptionObject `json:"options"`
+}
+
+// PlainTextInputElement - text input of input block
+type PlainTextInputElement struct {
+	ActionID     string      `json:"action_id,omitempty"`
+	Placeholder  *TextObject `json:"placeholder,omitempty"`
+	InitialValue string      `json:"initial_value,omitempty"`
+	Multiline    bool        `json:"multiline,omitempty"`
+}
+
+// DatePickerElement - calendar date picker
+type DatePickerElement struct {
+	ActionID    string      `json:"action_id,omitempty"`
+	Placeholder *TextObject `json:"placeholder,omitempty"`
+	// InitialDate - YYYY-MM-DD
+	InitialDate string `json:"initial_date,omitempty"`
+}
+
+func (b *SectionBlock) BlockType() string { return "section" }
+func (b *ContextBlock) BlockType() string { return "context" }
+func (b *DividerBlock) BlockType() string { return "divider" }
+func (b *ActionsBlock) BlockType() string { return "actions" }
+func (b *ImageBlock) BlockType() string   { return "image" }
+func (b *InputBlock) BlockType() string   { return "input" }
+
+func (e *ButtonElement) ElementType() string         { return "button" }
+func (e *ImageElement) ElementType() string          { return "image" }
+func (e *StaticSelectElement) ElementType() string   { return "static_select" }
+func (e *PlainTextInputElement) ElementType() string { return "plain_text_input" }
+func (e *DatePickerElement) ElementType() string     { return "datepicker" }
+
+// withType - JSON of v with "type" field added
+func withType(typ string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := json.Marshal(typ)
+	if string(data) == "{}" {
+		return []byte(`{"type":` + string(t) + `}`), nil
+	}
+	return append([]byte(`{"type":`+string(t)+`,`), data[1:]...), nil
+}
+
+// Aliases without methods - marshalled by withType without recursion
+type (
+	sectionJSON        SectionBlock
+	contextJSON        ContextBlock
+	dividerJSON        DividerBlock
+	actionsJSON        ActionsBlock
+	imageBlockJSON     ImageBlock
+	inputJSON          InputBlock
+	buttonJSON         ButtonElement
+	imageElementJSON   ImageElement
+	staticSelectJSON   StaticSelectElement
+	plainTextInputJSON PlainTextInputElement
+	datePickerJSON     DatePickerElement
+)
+
+// MarshalJSON - blocks and elements are written with their type
+func (b *SectionBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*sectionJSON)(b))
+}
+
+func (b *ContextBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*contextJSON)(b))
+}
+
+func (b *DividerBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*dividerJSON)(b))
+}
+
+func (b *ActionsBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*actionsJSON)(b))
+}
+
+func (b *ImageBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*imageBlockJSON)(b))
+}
+
+func (b *InputBlock) MarshalJSON() ([]byte, error) {
+	return withType(b.BlockType(), (*inputJSON)(b))
+}
+
+func (e *ButtonElement) MarshalJSON() ([]byte, error) {
+	return withType(e.ElementType(), (*buttonJSON)(e))
+}
+
+func (e *ImageElement) MarshalJSON() ([]byte, error) {
+	return withType(e.ElementType(), (*imageElementJSON)(e))
+}
+
+func (e *StaticSelectElement) MarshalJSON() ([]byte, error) {
+	return withType(e.ElementType(), (*staticSelectJSON)(e))
+}
+
+func (e *PlainTextInputElement) MarshalJSON() ([]byte, error) {
+	return withType(e.ElementType(), (*plainTextInputJSON)(e))
+}
+
+func (e *DatePickerElement) MarshalJSON() ([]byte, error) {
+	return withType(e.ElementType(), (*datePickerJSON)(e))
+}
+
+// checkLen - error if s is longer than max characters
+func checkLen(what string, s string, max int) error {
+	if n := utf8.RuneCountInString(s); n > max {
+		return fmt.Errorf("%s has %d characters, max %d", what, n, max)
+	}
+	return nil
+}
+
+// checkText - text object is valid and not longer than max
+func checkText(what string, t *TextObject, max int, required bool) error {
+	if t == nil {
+		if required {
+			return fmt.Errorf("%s missing", what)
+		}
+		return nil
+	}
+	if err := t.validate(); err != nil {
+		return fmt.Errorf("%s: %v", what, err)
+	}
+	return checkLen(what, t.Text, max)
+}
+
+// firstError - first non nil error
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *SectionBlock) validate() error {
+	if b.Text == nil && len(b.Fields) == 0 {
+		return fmt.Errorf("section needs text or fields")
+	}
+	if len(b.Fields) > maxSectionFields {
+		return fmt.Errorf("section has %d fields, max %d", len(b.Fields), maxSectionFields)
+	}
+	for i, f := range b.Fields {
+		if err := checkText(fmt.Sprintf("field %d", i+1), f, maxSectionFieldLen, true); err != nil {
+			return err
+		}
+	}
+	if b.Accessory != nil {
+		if err := b.Accessory.validate(); err != nil {
+			return fmt.Errorf("accessory: %v", err)
+		}
+	}
+	return firstError(checkLen("block_id", b.BlockID, maxBlockIDLen), checkText("text", b.Text, maxSectionTextLen, false))
+}
+
+func (b *ContextBlock) validate() error {
+	if len(b.Elements) == 0 || len(b.Elements) > maxContextElements {
+		return fmt.Errorf("context has %d elements, must have 1 to %d", len(b.Elements), maxContextElements)
+	}
+	for i, e := range b.Elements {
+		switch e.(type) {
+		case *TextObject, *ImageElement:
+		default:
+			return fmt.Errorf("element %d: %s not allowed in context", i+1, e.ElementType())
+		}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("element %d: %v", i+1, err)
+		}
+	}
+	return checkLen("block_id", b.BlockID, maxBlockIDLen)
+}
+
+func (b *DividerBlock) validate() error {
+	return checkLen("block_id", b.BlockID, maxBlockIDLen)
+}
+
+func (b *ActionsBlock) validate() error {
+	if len(b.Elements) == 0 || len(b.Elements) > maxActionsElements {
+		return fmt.Errorf("actions has %d elements, must have 1 to %d", len(b.Elements), maxActionsElements)
+	}
+	for i, e := range b.Elements {
+		if _, ok := e.(*TextObject); ok {
+			return fmt.Errorf("element %d: text not allowed in actions", i+1)
+		}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("element %d: %v", i+1, err)
+		}
+	}
+	return checkLen("block_id", b.BlockID, maxBlockIDLen)
+}
+
+func (b *ImageBlock) validate() error {
+	if b.ImageURL == "" || b.AltText == "" {
+		return fmt.Errorf("image needs image_url and alt_text")
+	}
+	return firstError(checkLen("block_id", b.BlockID, maxBlockIDLen), checkLen("image_url", b.ImageURL, maxImageURLLen),
+		checkLen("alt_text", b.AltText, maxAltTextLen), checkText("title", b.Title, maxLabelLen, false))
+}
+
+func (b *InputBlock) validate() error {
+	if b.Label != nil && b.Label.Type != TextPlain {
+		return fmt.Errorf("label must be plain_text")
+	}
+	if b.Element == nil {
+		return fmt.Errorf("input needs element")
+	}
+	if err := b.Element.validate(); err != nil {
+		return fmt.Errorf("element: %v", err)
+	}
+	return firstError(checkLen("block_id", b.BlockID, maxBlockIDLen), checkText("label", b.Label, maxLabelLen, true),
+		checkText("hint", b.Hint, maxLabelLen, false))
+}
+
+func (e *ButtonElement) validate() error {
+	if e.Text != nil && e.Text.Type != TextPlain {
+		return fmt.Errorf("button text must be plain_text")
+	}
+	if e.Style != ButtonDefault && e.Style != ButtonPrimary && e.Style != ButtonDanger {
+		return fmt.Errorf("unknown button style '%s'", e.Style)
+	}
+	return firstError(checkText("button text", e.Text, maxButtonTextLen, true), checkLen("action_id", e.ActionID, maxActionIDLen),
+		checkLen("url", e.URL, maxImageURLLen), checkLen("value", e.Value, maxButtonValueLen))
+}
+
+func (e *ImageElement) validate() error {
+	if e.ImageURL == "" || e.AltText == "" {
+		return fmt.Errorf("image needs image_url and alt_text")
+	}
+	return firstError(checkLen("image_url", e.ImageURL, maxImageURLLen), checkLen("alt_text", e.AltText, maxAltTextLen))
+}
+
+func (e *StaticSelectElement) validate() error {
+	if len(e.Options) == 0 || len(e.Options) > maxSelectOptions {
+		return fmt.Errorf("select has %d options, must have 1 to %d", len(e.Options), maxSelectOptions)
+	}
+	for i, o := range e.Options {
+		if err := firstError(checkText(fmt.Sprintf("option %d", i+1), o.Text, maxOptionTextLen, true),
+			checkLen(fmt.Sprintf("option %d value", i+1), o.Value, maxOptionValueLen)); err != nil {
+			return err
+		}
+	}
+	return firstError(checkText("placeholder", e.Placeholder, maxPlaceholderLen, true), checkLen("action_id", e.ActionID, maxActionIDLen))
+}
+
+func (e *PlainTextInputElement) validate() error {
+	return firstError(checkText("placeholder", e.Placeholder, maxPlaceholderLen, false), checkLen("action_id", e.ActionID, maxActionIDLen),
+		checkLen("initial_value", e.InitialValue, maxInputInitialValueLn))
+}
+
+func (e *DatePickerElement) validate() error {
+	return firstError(checkText("placeholder", e.Placeholder, maxPlaceholderLen, false), checkLen("action_id", e.ActionID, maxActionIDLen))
+}
+
+func (b *SectionBlock) fallback() string {
+	var parts []string
+	if b.Text != nil {
+		parts = append(parts, b.Text.Text)
+	}
+	for _, f := range b.Fields {
+		parts = append(parts, f.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (b *ContextBlock) fallback() string {
+	var parts []string
+	for _, e := range b.Elements {
+		if t, ok := e.(*TextObject); ok {
+			parts = append(parts, t.Text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (b *DividerBlock) fallback() string { return "" }
+
+func (b *ActionsBlock) fallback() string {
+	var parts []string
+	for _, e := range b.Elements {
+		if btn, ok := e.(*ButtonElement); ok && btn.Text != nil {
+			parts = append(parts, "["+btn.Text.Text+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (b *ImageBlock) fallback() string {
+	if b.Title != nil {
+		return b.Title.Text
+	}
+	return b.AltText
+}
+
+func (b *InputBlock) fallback() string {
+	if b.Label != nil {
+		return b.Label.Text
+	}
+	return ""
+}
+
+// ValidateBlocks - check blocks against Slack limits
+func ValidateBlocks(blocks []Block) error {
+	if len(blocks) > maxBlocks {
+		return fmt.Errorf("message has %d blocks, max %d", len(blocks), maxBlocks)
+	}
+	for i, b := range blocks {
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("block %d (%s): %v", i+1, b.BlockType(), err)
+		}
+	}
+	return nil
+}
+
+// BlocksText - text fallback of blocks, used as message text for notifications and old clients
+func BlocksText(blocks []Block) string {
+	var lines []string
+	for _, b := range blocks {
+		if text := b.fallback(); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// BlockBuilder - fluent builder of message blocks. Errors are collected and returned by Build
+type BlockBuilder struct {
+	blocks []Block
+}
+
+// NewBlocks - start building message blocks
+func NewBlocks() *BlockBuilder {
+	return &BlockBuilder{}
+}
+
+// Add - any block
+func (bb *BlockBuilder) Add(b Block) *BlockBuilder {
+	bb.blocks = append(bb.blocks, b)
+	return bb
+}
+
+// Section - mrkdwn text with optional mrkdwn fields shown in two columns
+func (bb *BlockBuilder) Section(text string, fields ...string) *BlockBuilder {
+	s := &SectionBlock{}
+	if text != "" {
+		s.Text = MarkdownText(text)
+	}
+	for _, f := range fields {
+		s.Fields = append(s.Fields, MarkdownText(f))
+	}
+	return bb.Add(s)
+}
+
+// SectionWithAccessory - mrkdwn text with element (button, image, select) on the right
+func (bb *BlockBuilder) SectionWithAccessory(text string, accessory BlockElement) *BlockBuilder {
+	return bb.Add(&SectionBlock{Text: MarkdownText(text), Accessory: accessory})
+}
+
+// Context - small mrkdwn texts
+func (bb *BlockBuilder) Context(texts ...string) *BlockBuilder {
+	c := &ContextBlock{}
+	for _, t := range texts {
+		c.Elements = append(c.Elements, MarkdownText(t))
+	}
+	return bb.Add(c)
+}
+
+// Divider - horizontal line
+func (bb *BlockBuilder) Divider() *BlockBuilder {
+	return bb.Add(&DividerBlock{})
+}
+
+// Actions - interactive elements
+func (bb *BlockBuilder) Actions(elements ...BlockElement) *BlockBuilder {
+	return bb.Add(&ActionsBlock{Elements: elements})
+}
+
+// Image - image with optional title
+func (bb *BlockBuilder) Image(url string, altText string, title string) *BlockBuilder {
+	img := &ImageBlock{ImageURL: url, AltText: altText}
+	if title != "" {
+		img.Title = PlainText(title)
+	}
+	return bb.Add(img)
+}
+
+// Input - labelled input element
+func (bb *BlockBuilder) Input(label string, element BlockElement, optional bool) *BlockBuilder {
+	return bb.Add(&InputBlock{Label: PlainText(label), Element: element, Optional: optional})
+}
+
+// Build - validated blocks
+func (bb *BlockBuilder) Build() ([]Block, error) {
+	if err := ValidateBlocks(bb.blocks); err != nil {
+		return nil, err
+	}
+	return bb.blocks, nil
+}
+
+// Button - button element. Give url for link button or value for interactive one
+func Button(text string, actionID string, value string) *ButtonElement {
+	return &ButtonElement{Text: PlainText(text), ActionID: actionID, Value: value}
+}
+
+// LinkButton - button opening URL
+func LinkButton(text string, url string) *ButtonElement {
+	return &ButtonElement{Text: PlainText(text), URL: url}
+}
+
+// Select - static select with options given as text-value pairs
+func Select(placeholder string, actionID string, options ...*OptionObject) *StaticSelectElement {
+	return &StaticSelectElement{Placeholder: PlainText(placeholder), ActionID: actionID, Options: options}
+}
+
+// Option - select option
+func Option(text string, value string) *OptionObject {
+	return &OptionObject{Text: PlainText(text), Value: value}
+}
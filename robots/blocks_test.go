@@ -0,0 +1,162 @@
+package robots
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBlocksJSON(t *testing.T) {
+	blocks, err := NewBlocks().
+		Section("*Deploy* finished", "*Env*\nprod", "*Took*\n42s").
+		SectionWithAccessory("Logs are ready", LinkButton("Open", "https://ci.example.com/1")).
+		Context("by <@U0123ABCD>", "build 1").
+		Divider().
+		Actions(
+			&ButtonElement{Text: PlainText("Roll back"), ActionID: "rollback", Value: "1", Style: ButtonDanger},
+			Select("Environment", "env", Option("Production", "prod"), Option("Staging", "stage")),
+		).
+		Image("https://ci.example.com/graph.png", "build times", "Build times").
+		Input("Comment", &PlainTextInputElement{ActionID: "comment", Multiline: true}, true).
+		Input("Date", &DatePickerElement{ActionID: "date", InitialDate: "2016-01-31"}, false).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	golden(t, "blocks_full", blocks)
+	golden(t, "blocks_divider", []Block{&DividerBlock{}})
+}
+
+func TestWithType(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"empty object", struct{}{}, `{"type":"divider"}`},
+		{"fields after type", struct {
+			A string `json:"a"`
+		}{"x"}, `{"type":"divider","a":"x"}`},
+		{"nested typed values keep their type", (*sectionJSON)(&SectionBlock{Accessory: &ImageElement{ImageURL: "u", AltText: "a"}}),
+			`{"type":"divider","accessory":{"type":"image","image_url":"u","alt_text":"a"}}`},
+	}
+	for _, tt := range tests {
+		got, err := withType("divider", tt.v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: withType = %s, want %s", tt.name, got, tt.want)
+		}
+		if !json.Valid(got) {
+			t.Errorf("%s: invalid JSON %s", tt.name, got)
+		}
+	}
+}
+
+func TestValidateBlocks(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("x", n) }
+	many := func(n int, b Block) []Block {
+		blocks := make([]Block, n)
+		for i := range blocks {
+			blocks[i] = b
+		}
+		return blocks
+	}
+	texts := func(n int) []*TextObject {
+		fields := make([]*TextObject, n)
+		for i := range fields {
+			fields[i] = MarkdownText("f")
+		}
+		return fields
+	}
+	buttons := func(n int) []BlockElement {
+		elements := make([]BlockElement, n)
+		for i := range elements {
+			elements[i] = Button("b", "a", "v")
+		}
+		return elements
+	}
+	options := func(n int) []*OptionObject {
+		opts := make([]*OptionObject, n)
+		for i := range opts {
+			opts[i] = Option("o", "v")
+		}
+		return opts
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []Block
+		wantErr string
+	}{
+		{"max blocks", many(maxBlocks, &DividerBlock{}), ""},
+		{"too many blocks", many(maxBlocks+1, &DividerBlock{}), "51 blocks, max 50"},
+		{"section text at limit", []Block{&SectionBlock{Text: MarkdownText(long(maxSectionTextLen))}}, ""},
+		{"section text over limit", []Block{&SectionBlock{Text: MarkdownText(long(maxSectionTextLen + 1))}}, "text has 3001 characters"},
+		{"section text counted in characters", []Block{&SectionBlock{Text: MarkdownText(strings.Repeat("ł", maxSectionTextLen))}}, ""},
+		{"empty section", []Block{&SectionBlock{}}, "needs text or fields"},
+		{"max fields", []Block{&SectionBlock{Fields: texts(maxSectionFields)}}, ""},
+		{"too many fields", []Block{&SectionBlock{Fields: texts(maxSectionFields + 1)}}, "11 fields"},
+		{"field over limit", []Block{&SectionBlock{Fields: []*TextObject{MarkdownText(long(maxSectionFieldLen + 1))}}}, "field 1 has 2001"},
+		{"empty text", []Block{&SectionBlock{Text: MarkdownText("")}}, "empty text"},
+		{"unknown text type", []Block{&SectionBlock{Text: &TextObject{Type: "html", Text: "x"}}}, "unknown text type"},
+		{"block_id over limit", []Block{&DividerBlock{BlockID: long(maxBlockIDLen + 1)}}, "block_id"},
+		{"empty context", []Block{&ContextBlock{}}, "context has 0 elements"},
+		{"button in context", []Block{&ContextBlock{Elements: []BlockElement{Button("b", "a", "v")}}}, "button not allowed in context"},
+		{"max actions", []Block{&ActionsBlock{Elements: buttons(maxActionsElements)}}, ""},
+		{"too many actions", []Block{&ActionsBlock{Elements: buttons(maxActionsElements + 1)}}, "actions has 26 elements"},
+		{"text in actions", []Block{&ActionsBlock{Elements: []BlockElement{MarkdownText("x")}}}, "text not allowed in actions"},
+		{"button text over limit", []Block{&ActionsBlock{Elements: []BlockElement{Button(long(maxButtonTextLen+1), "a", "v")}}}, "button text has 76"},
+		{"mrkdwn button", []Block{&ActionsBlock{Elements: []BlockElement{&ButtonElement{Text: MarkdownText("b")}}}}, "must be plain_text"},
+		{"unknown button style", []Block{&ActionsBlock{Elements: []BlockElement{&ButtonElement{Text: PlainText("b"), Style: "blue"}}}}, "unknown button style"},
+		{"too many options", []Block{&ActionsBlock{Elements: []BlockElement{Select("p", "a", options(maxSelectOptions+1)...)}}}, "101 options"},
+		{"option value over limit", []Block{&ActionsBlock{Elements: []BlockElement{Select("p", "a", Option("o", long(maxOptionValueLen+1)))}}}, "option 1 value"},
+		{"image without alt text", []Block{&ImageBlock{ImageURL: "https://x"}}, "needs image_url and alt_text"},
+		{"input without element", []Block{&InputBlock{Label: PlainText("l")}}, "needs element"},
+		{"input without label", []Block{&InputBlock{Element: &DatePickerElement{}}}, "label missing"},
+		{"mrkdwn label", []Block{&InputBlock{Label: MarkdownText("l"), Element: &DatePickerElement{}}}, "label must be plain_text"},
+		{"input initial value over limit", []Block{&InputBlock{Label: PlainText("l"),
+			Element: &PlainTextInputElement{InitialValue: long(maxInputInitialValueLn + 1)}}}, "initial_value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBlocks(tt.blocks)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateBlocks: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateBlocks error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildReportsBlockNumber(t *testing.T) {
+	_, err := NewBlocks().Divider().Section("").Build()
+	if err == nil || !strings.HasPrefix(err.Error(), "block 2 (section)") {
+		t.Errorf("Build error = %v, want error of block 2", err)
+	}
+}
+
+func TestBlocksText(t *testing.T) {
+	blocks := []Block{
+		&SectionBlock{Text: MarkdownText("*Deploy* done"), Fields: []*TextObject{MarkdownText("env: prod")}},
+		&DividerBlock{},
+		&ContextBlock{Elements: []BlockElement{MarkdownText("by bob"), &ImageElement{ImageURL: "u", AltText: "a"}, PlainText("now")}},
+		&ActionsBlock{Elements: []BlockElement{Button("Roll back", "a", "v"), Select("Env", "e", Option("o", "v"))}},
+		&ImageBlock{ImageURL: "u", AltText: "graph"},
+		&ImageBlock{ImageURL: "u", AltText: "graph", Title: PlainText("Build times")},
+		&InputBlock{Label: PlainText("Comment"), Element: &PlainTextInputElement{}},
+	}
+	want := "*Deploy* done\nenv: prod\nby bob now\n[Roll back]\ngraph\nBuild times\nComment"
+	if got := BlocksText(blocks); got != want {
+		t.Errorf("BlocksText =\n%s\nwant\n%s", got, want)
+	}
+	if got := BlocksText(nil); got != "" {
+		t.Errorf("BlocksText(nil) = %q", got)
+	}
+}
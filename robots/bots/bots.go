@@ -1,6 +1,13 @@
 package directory
 
-import "github.com/wojtekzw/slackbot/robots"
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
+)
 
 type bot struct{}
 
@@ -10,13 +17,40 @@ func init() {
 }
 
 func (r *bot) Run(p *robots.Payload) (slashCommandImmediateReturn string) {
-	output := ""
-	for command, robs := range robots.Robots {
-		for _, r := range robs {
-			output = output + "\n" + command + " - " + r.Description() + "\n"
+	go r.DeferredAction(p)
+	return ""
+}
+
+// DeferredAction - send list of commands. The list can be over Slack message limit, so it is not
+// returned as immediate answer (that is not split) but sent as message which is split into parts
+func (r *bot) DeferredAction(p *robots.Payload) {
+	var d *robots.Delivery
+	if p.ResponseUrl == "" {
+		// Outgoing webhook, RTM or Events API - posted where the command was typed with configured sender
+		d = p.Reply("", r.list()).Send()
+	} else {
+		d = robots.SlashCommandResponse{Text: r.list()}.Send(p)
+	}
+	if err := d.Wait(); err != nil {
+		log.Printf("Error sending command list: %v", err)
+	}
+}
+
+// list - commands with descriptions, sorted
+func (r *bot) list() string {
+	var commands []string
+	for command := range robots.Robots {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var sections []string
+	for _, command := range commands {
+		for _, r := range robots.Robots[command] {
+			sections = append(sections, mrkdwn.Bold("/"+command)+" - "+mrkdwn.Escape(r.Description()))
 		}
 	}
-	return output
+	return strings.Join(sections, "\n\n")
 }
 
 func (r *bot) Description() (description string) {
@@ -0,0 +1,50 @@
+package robots
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
+	"github.com/wojtekzw/slackbot/rtm"
+)
+
+type bot struct {
+	burner *rtm.BurnScheduler
+}
+
+func init() {
+	r := &bot{burner: rtm.Burner}
+	robots.RegisterRobot("burn", r)
+}
+
+func (r bot) Run(p *robots.Payload) (slashCommandImmediateReturn string) {
+	args := strings.SplitN(strings.TrimSpace(p.Text), " ", 2)
+	if len(args) != 2 || strings.TrimSpace(args[1]) == "" {
+		return r.Description()
+	}
+	after, err := rtm.ParseBurnDuration(args[0])
+	if err != nil {
+		return err.Error()
+	}
+	go r.DeferredAction(p, strings.TrimSpace(args[1]), after)
+	return ""
+}
+
+func (r bot) DeferredAction(p *robots.Payload, text string, after time.Duration) {
+	err := r.burner.Post(p.ChannelID, mrkdwn.User(p.UserID)+": "+mrkdwn.Quiet(text), after)
+	if err == nil {
+		return
+	}
+
+	log.Printf("Error posting burn message: %v", err)
+	response := &robots.SlashCommandResponse{Text: "Can't post your message: " + mrkdwn.Escape(err.Error())}
+	if err := response.Send(p).Wait(); err != nil {
+		log.Printf("Error sending burn error: %v", err)
+	}
+}
+
+func (r bot) Description() (description string) {
+	return "Self-destructing messages\n\tUsage: /burn 10m <text>\n\tReact to your own message with the burn emoji (:fire: by default) to delete it after a while"
+}
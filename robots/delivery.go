@@ -0,0 +1,327 @@
+package robots
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	defaultDeliveryWorkers     = 4
+	defaultDeliveryMaxAttempts = 5
+	deliveryBaseDelay          = time.Second
+	deliveryMaxDelay           = 2 * time.Minute
+	deliveryTimeout            = 30 * time.Second
+	deadLetterFile             = "dead_letter.log"
+)
+
+// Delivery - handle of a queued message. Wait for it if you care about the result,
+// Update or Delete it to change the posted message
+type Delivery struct {
+	URL      string
+	Payload  []byte
+	Attempts int
+	// Channel and Timestamp of posted message. Set only by senders which get them from Slack (Web API)
+	Channel   string
+	Timestamp string
+	// FileID - snippet file the message was uploaded as (see SLACKBOT_LONG_MESSAGES)
+	FileID string
+
+	// key - messages with the same key are sent in order
+	key  string
+	post postFunc
+	// sender - sender which posted the message, used to update and delete it
+	sender Sender
+	// followUp - message with attachments sent after snippet
+	followUp *Delivery
+	done     chan struct{}
+	err      error
+}
+
+// postFunc - one delivery attempt. retry is false for errors which won't go away (e.g. invalid payload)
+type postFunc func(client *http.Client, d *Delivery) (retryAfter time.Duration, retry bool, err error)
+
+// Wait - block until message is delivered or given up. Returns last error
+func (d *Delivery) Wait() error {
+	<-d.done
+	return d.err
+}
+
+// Done - closed when message is delivered or given up
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *Delivery) finish(err error) {
+	d.err = err
+	close(d.done)
+}
+
+// Update - replace posted message, e.g. progress message with the final answer.
+// Waits until the message is posted. Returned delivery can be updated again
+func (d *Delivery) Update(m Message) *Delivery {
+	if err := d.Wait(); err != nil {
+		return failedDelivery(fmt.Errorf("can't update message which was not posted: %v", err))
+	}
+	if d.FileID != "" {
+		return failedDelivery(fmt.Errorf("message was sent as snippet %s - snippets can't be edited, delete it and send again", d.FileID))
+	}
+	if d.sender == nil {
+		return failedDelivery(fmt.Errorf("message can't be updated (e.g. ephemeral)"))
+	}
+	return d.sender.Update(d, m)
+}
+
+// Delete - delete posted message. Waits until the message is posted
+func (d *Delivery) Delete() *Delivery {
+	if err := d.Wait(); err != nil {
+		return failedDelivery(fmt.Errorf("can't delete message which was not posted: %v", err))
+	}
+	if d.FileID != "" {
+		return deleteSnippet(d)
+	}
+	if d.sender == nil {
+		return failedDelivery(fmt.Errorf("message can't be deleted (e.g. ephemeral)"))
+	}
+	return d.sender.Delete(d)
+}
+
+// failedDelivery - handle of a message which could not be queued (e.g. no URL configured)
+func failedDelivery(err error) *Delivery {
+	d := &Delivery{done: make(chan struct{})}
+	d.finish(err)
+	return d
+}
+
+// destination - messages with one key (URL or channel), sent in order by one worker at a time.
+// Message waiting for retry stays first and the destination is busy until it is ready again
+type destination struct {
+	pending []*Delivery
+	busy    bool
+}
+
+// DeliveryQueue - outbound messages sent by bounded workers with retries, exponential backoff
+// and Retry-After support. Messages to the same destination are sent in order. Messages which keep
+// failing are written to the dead-letter log
+type DeliveryQueue struct {
+	Workers        int
+	MaxAttempts    int
+	DeadLetterPath string
+	client         *http.Client
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	dests map[string]*destination
+	ready []string
+	start sync.Once
+	// deadLetterMu - dead-letter log writes, kept apart from mu so file I/O doesn't stop the queue
+	deadLetterMu sync.Mutex
+}
+
+// Outbox - queue used by Message sending. Configured with SLACKBOT_DELIVERY_WORKERS
+// and SLACKBOT_DELIVERY_MAX_ATTEMPTS on first use
+var Outbox = &DeliveryQueue{}
+
+func (q *DeliveryQueue) init() {
+	q.start.Do(func() {
+		if q.Workers <= 0 {
+			q.Workers = envInt("SLACKBOT_DELIVERY_WORKERS", defaultDeliveryWorkers)
+		}
+		if q.MaxAttempts <= 0 {
+			q.MaxAttempts = envInt("SLACKBOT_DELIVERY_MAX_ATTEMPTS", defaultDeliveryMaxAttempts)
+		}
+		if q.DeadLetterPath == "" {
+			q.DeadLetterPath = utils.DataPath(deadLetterFile)
+		}
+		q.client = &http.Client{Timeout: deliveryTimeout}
+		q.cond = sync.NewCond(&q.mu)
+		q.dests = make(map[string]*destination)
+		for i := 0; i < q.Workers; i++ {
+			go q.worker()
+		}
+	})
+}
+
+// Enqueue - queue payload to be posted to webhook URL as form value "payload"
+func (q *DeliveryQueue) Enqueue(u string, payload []byte) *Delivery {
+	return q.enqueue(&Delivery{URL: u, Payload: payload, key: u, post: postWebhook})
+}
+
+func (q *DeliveryQueue) enqueue(d *Delivery) *Delivery {
+	q.init()
+	d.done = make(chan struct{})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dest, ok := q.dests[d.key]
+	if !ok {
+		dest = &destination{}
+		q.dests[d.key] = dest
+	}
+	dest.pending = append(dest.pending, d)
+	if !dest.busy {
+		dest.busy = true
+		q.ready = append(q.ready, d.key)
+		q.cond.Signal()
+	}
+	return d
+}
+
+// worker - take destination with pending messages and send them in order. Message to retry later
+// puts its destination aside until the wait is over, so the worker goes on with other destinations
+func (q *DeliveryQueue) worker() {
+	for {
+		q.mu.Lock()
+		for len(q.ready) == 0 {
+			q.cond.Wait()
+		}
+		u := q.ready[0]
+		q.ready = q.ready[1:]
+		q.mu.Unlock()
+
+		for {
+			q.mu.Lock()
+			dest := q.dests[u]
+			if len(dest.pending) == 0 {
+				dest.busy = false
+				delete(q.dests, u)
+				q.mu.Unlock()
+				break
+			}
+			d := dest.pending[0]
+			q.mu.Unlock()
+
+			if wait, retry := q.deliver(d); retry {
+				time.AfterFunc(wait, func() { q.requeue(u) })
+				break
+			}
+
+			q.mu.Lock()
+			dest.pending = dest.pending[1:]
+			q.mu.Unlock()
+		}
+	}
+}
+
+// requeue - destination waiting for retry is ready again
+func (q *DeliveryQueue) requeue(u string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ready = append(q.ready, u)
+	q.cond.Signal()
+}
+
+// deliver - one attempt to post. Returns retry true and time to wait if it should be tried again.
+// Gives up after MaxAttempts or on error which retry won't fix
+func (q *DeliveryQueue) deliver(d *Delivery) (wait time.Duration, retry bool) {
+	d.Attempts++
+	retryAfter, retry, err := d.post(q.client, d)
+	if err == nil {
+		d.finish(nil)
+		return 0, false
+	}
+	if !retry || d.Attempts >= q.MaxAttempts {
+		log.Printf("Delivery to %s failed after %d attempt(s): %v", redactURL(d.URL), d.Attempts, err)
+		q.deadLetter(d, err)
+		d.finish(err)
+		return 0, false
+	}
+
+	wait = backoff(d.Attempts)
+	if retryAfter > 0 {
+		wait = retryAfter
+	}
+	log.Printf("Delivery to %s failed (attempt %d), retrying in %s: %v", redactURL(d.URL), d.Attempts, wait, err)
+	return wait, true
+}
+
+// postWebhook - post payload to incoming webhook or response URL
+func postWebhook(client *http.Client, d *Delivery) (retryAfter time.Duration, retry bool, err error) {
+	data := url.Values{}
+	data.Set("payload", string(d.Payload))
+
+	resp, err := client.PostForm(d.URL, data)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return checkResponse(resp, body)
+}
+
+// checkResponse - classify HTTP status: 429 and 5xx are retried, 429 after Retry-After
+func checkResponse(resp *http.Response, body []byte) (retryAfter time.Duration, retry bool, err error) {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return 0, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(secs) * time.Second, true, fmt.Errorf("rate limited: %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return 0, true, fmt.Errorf("server error: %s %s", resp.Status, body)
+	}
+	return 0, false, fmt.Errorf("non-200 response: %s %s", resp.Status, body)
+}
+
+// deadLetter - append message which couldn't be delivered to the dead-letter log (JSON lines)
+func (q *DeliveryQueue) deadLetter(d *Delivery, deliveryErr error) {
+	entry, _ := json.Marshal(struct {
+		Time     time.Time       `json:"time"`
+		URL      string          `json:"url"`
+		Attempts int             `json:"attempts"`
+		Error    string          `json:"error"`
+		Payload  json.RawMessage `json:"payload"`
+	}{time.Now(), redactURL(d.URL), d.Attempts, deliveryErr.Error(), d.Payload})
+
+	q.deadLetterMu.Lock()
+	defer q.deadLetterMu.Unlock()
+	f, err := os.OpenFile(q.DeadLetterPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Error opening dead-letter log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		log.Printf("Error writing dead-letter log: %v", err)
+	}
+}
+
+// backoff - exponential delay before retry: 1s, 2s, 4s... up to deliveryMaxDelay
+func backoff(attempt int) time.Duration {
+	d := deliveryBaseDelay << uint(attempt-1)
+	if d <= 0 || d > deliveryMaxDelay {
+		return deliveryMaxDelay
+	}
+	return d
+}
+
+// redactURL - URL without path, webhook and response URLs are secrets
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/..."
+}
+
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s '%s', using %d", name, v, def)
+		return def
+	}
+	return n
+}
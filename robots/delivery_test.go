@@ -0,0 +1,146 @@
+package robots
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer - incoming webhook answering with statuses in order (200 when they run out).
+// Records payloads and arrival times
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses map[string][]int
+	payloads []string
+	times    []time.Time
+}
+
+func newWebhookServer(t *testing.T, statuses map[string][]int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.payloads = append(s.payloads, r.FormValue("payload"))
+		s.times = append(s.times, time.Now())
+		if left := s.statuses[r.URL.Path]; len(left) > 0 {
+			s.statuses[r.URL.Path] = left[1:]
+			if left[0] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(left[0])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.payloads...)
+}
+
+func testQueue(t *testing.T, workers int) *DeliveryQueue {
+	return &DeliveryQueue{Workers: workers, MaxAttempts: 3, DeadLetterPath: filepath.Join(t.TempDir(), deadLetterFile)}
+}
+
+func deadLetters(t *testing.T, q *DeliveryQueue) string {
+	data, err := ioutil.ReadFile(q.DeadLetterPath)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDeliveryRateLimitedWaitsRetryAfter(t *testing.T) {
+	srv := newWebhookServer(t, map[string][]int{"/hook": {http.StatusTooManyRequests}})
+	q := testQueue(t, 1)
+
+	d := q.Enqueue(srv.URL+"/hook", []byte(`{"text":"a"}`))
+	if err := d.Wait(); err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+	if d.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", d.Attempts)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if wait := srv.times[1].Sub(srv.times[0]); wait < time.Second {
+		t.Errorf("retried after %s, Retry-After is 1s", wait)
+	}
+}
+
+func TestDeliveryServerErrorRetried(t *testing.T) {
+	srv := newWebhookServer(t, map[string][]int{"/hook": {http.StatusServiceUnavailable}})
+	q := testQueue(t, 1)
+
+	d := q.Enqueue(srv.URL+"/hook", []byte(`{"text":"a"}`))
+	if err := d.Wait(); err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+	if d.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", d.Attempts)
+	}
+	if got := deadLetters(t, q); got != "" {
+		t.Errorf("delivered message in dead-letter log: %s", got)
+	}
+}
+
+func TestDeliveryPermanentErrorDeadLettered(t *testing.T) {
+	srv := newWebhookServer(t, map[string][]int{"/hook/secret": {http.StatusNotFound}})
+	q := testQueue(t, 1)
+
+	d := q.Enqueue(srv.URL+"/hook/secret", []byte(`{"text":"lost"}`))
+	if err := d.Wait(); err == nil {
+		t.Fatal("delivery to 404 succeeded")
+	}
+	if d.Attempts != 1 {
+		t.Errorf("attempts = %d, 4xx must not be retried", d.Attempts)
+	}
+	got := deadLetters(t, q)
+	if !strings.Contains(got, `"payload":{"text":"lost"}`) || !strings.Contains(got, `"attempts":1`) {
+		t.Errorf("dead-letter log = %s", got)
+	}
+	if strings.Contains(got, "secret") {
+		t.Errorf("dead-letter log has webhook path: %s", got)
+	}
+}
+
+func TestDeliveryKeepsOrderWhileRetrying(t *testing.T) {
+	srv := newWebhookServer(t, map[string][]int{"/slow": {http.StatusInternalServerError}})
+	// One worker - retry wait must not hold it
+	q := testQueue(t, 1)
+
+	first := q.Enqueue(srv.URL+"/slow", []byte(`"1"`))
+	second := q.Enqueue(srv.URL+"/slow", []byte(`"2"`))
+	other := q.Enqueue(srv.URL+"/other", []byte(`"other"`))
+
+	select {
+	case <-other.Done():
+	case <-first.Done():
+		t.Fatal("retried message delivered before message to other destination")
+	case <-time.After(deliveryBaseDelay / 2):
+		t.Fatal("worker blocked by retry wait")
+	}
+	if err := second.Wait(); err != nil {
+		t.Fatalf("second delivery: %v", err)
+	}
+	if err := first.Wait(); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+
+	want := []string{`"1"`, `"other"`, `"1"`, `"2"`}
+	got := srv.received()
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
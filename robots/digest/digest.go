@@ -0,0 +1,65 @@
+package robots
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
+	"github.com/wojtekzw/slackbot/rtm"
+)
+
+type bot struct {
+	digest *rtm.DigestService
+}
+
+func init() {
+	r := &bot{digest: rtm.Digest}
+	robots.RegisterRobot("digest", r)
+}
+
+func (r bot) Run(p *robots.Payload) (slashCommandImmediateReturn string) {
+	switch strings.ToLower(strings.TrimSpace(p.Text)) {
+	case "on":
+		if err := r.digest.Enable(p.UserID, true); err != nil {
+			return "digest: " + mrkdwn.Escape(err.Error())
+		}
+		return "digest: " + mrkdwn.Bold("On") + " - mentions while you are away will be sent to you when you are back"
+	case "off":
+		if err := r.digest.Enable(p.UserID, false); err != nil {
+			return "digest: " + mrkdwn.Escape(err.Error())
+		}
+		return "digest: " + mrkdwn.Bold("Off") + " - collected mentions dropped"
+	case "now":
+		go r.DeferredAction(p)
+		return "digest: Sending..."
+	case "":
+		if r.digest.Enabled(p.UserID) {
+			return "digest: On"
+		}
+		return "digest: Off"
+	}
+	return r.Description()
+}
+
+// DeferredAction - send collected mentions now and report result
+func (r bot) DeferredAction(p *robots.Payload) {
+	response := &robots.SlashCommandResponse{}
+	n, err := r.digest.Send(p.UserID)
+	switch {
+	case err != nil:
+		response.Text = "digest: Error sending digest: " + mrkdwn.Escape(err.Error())
+	case n == 0:
+		response.Text = "digest: No mentions collected"
+	default:
+		response.Text = fmt.Sprintf("digest: %d mention(s) sent to you in direct message", n)
+	}
+	if err := response.Send(p).Wait(); err != nil {
+		log.Printf("Error sending digest result: %v", err)
+	}
+}
+
+func (r bot) Description() (description string) {
+	return "Mentions collected while you are away, sent when you are back\n\tUsage: /digest [on|off|now]"
+}
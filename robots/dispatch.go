@@ -0,0 +1,65 @@
+package robots
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// TriggerPrefix - messages starting with it run robots when they come from RTM or Events API,
+// e.g. "!ping" (SLACKBOT_TRIGGER). Empty - robots are run by slash commands and outgoing webhooks only,
+// so a message isn't handled twice when outgoing webhook uses the same trigger word
+func TriggerPrefix() string {
+	return os.Getenv("SLACKBOT_TRIGGER")
+}
+
+// ParseTrigger - robot name and arguments of message "<prefix>robot args". False if text is not a command
+func ParseTrigger(text string, prefix string) (robot string, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if prefix == "" || !strings.HasPrefix(text, prefix) {
+		return "", "", false
+	}
+	fields := strings.SplitN(strings.TrimPrefix(text, prefix), " ", 2)
+	if fields[0] == "" {
+		return "", "", false
+	}
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+	return fields[0], args, true
+}
+
+// RunMessage - run robots triggered by message from RTM or Events API. Robots run in background,
+// immediate return of a robot is posted with bot token as reply in the thread of the message
+// (or in the channel if message is at top level). False if message doesn't trigger a robot
+func RunMessage(msg *slack.Msg, teamDomain string) bool {
+	if msg.SubType != "" || msg.Hidden || msg.BotID != "" || msg.User == "" {
+		// Edits, bot messages (including our replies) don't run robots
+		return false
+	}
+	name, args, ok := ParseTrigger(msg.Text, TriggerPrefix())
+	if !ok {
+		return false
+	}
+	robots := Robots[name]
+	if len(robots) == 0 {
+		return false
+	}
+
+	p := NewPayload(msg, teamDomain, name)
+	p.Text = args
+	go func() {
+		for _, r := range robots {
+			text := r.Run(p)
+			if text == "" {
+				continue
+			}
+			if err := (SlashCommandResponse{Text: text}).Send(p).Wait(); err != nil {
+				log.Printf("Error sending %s reply: %v", name, err)
+			}
+		}
+	}()
+	return true
+}
@@ -0,0 +1,65 @@
+package robots
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestParseTrigger(t *testing.T) {
+	tests := []struct {
+		text, prefix string
+		robot, args  string
+		ok           bool
+	}{
+		{"!ping", "!", "ping", "", true},
+		{"  !burn 5m  secret text ", "!", "burn", "5m  secret text", true},
+		{"ping", "!", "", "", false},
+		{"! ping", "!", "", "", false},
+		{"!ping", "", "", "", false},
+	}
+	for _, tt := range tests {
+		robot, args, ok := ParseTrigger(tt.text, tt.prefix)
+		if robot != tt.robot || args != tt.args || ok != tt.ok {
+			t.Errorf("ParseTrigger(%q, %q) = %q, %q, %t, want %q, %q, %t", tt.text, tt.prefix, robot, args, ok, tt.robot, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestNewPayloadThread(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    slack.Msg
+		thread string
+	}{
+		{"top level stays top level", slack.Msg{Channel: "C1", Timestamp: "1500000000.000100"}, ""},
+		{"reply stays in thread", slack.Msg{Channel: "C1", Timestamp: "1500000001.000100", ThreadTimestamp: "1500000000.000100"}, "1500000000.000100"},
+	}
+	for _, tt := range tests {
+		p := NewPayload(&tt.msg, "team", "ping")
+		if p.ThreadTimestamp != tt.thread {
+			t.Errorf("%s: ThreadTimestamp = %q, want %q", tt.name, p.ThreadTimestamp, tt.thread)
+		}
+		if p.Reply("bot", "pong").ThreadTimestamp != tt.thread {
+			t.Errorf("%s: reply thread = %q, want %q", tt.name, p.Reply("bot", "pong").ThreadTimestamp, tt.thread)
+		}
+	}
+}
+
+func TestThreadUpToTrigger(t *testing.T) {
+	withFakeSlack(t)
+	p := NewPayload(&slack.Msg{Channel: "C1", Timestamp: "1500000001.000100", ThreadTimestamp: "1500000000.000100"}, "team", "summary")
+
+	messages, err := p.Thread()
+	if err != nil {
+		t.Fatalf("Thread: %v", err)
+	}
+	var texts []string
+	for _, m := range messages {
+		texts = append(texts, m.Text)
+	}
+	if want := []string{"parent", "trigger"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("Thread = %q, want %q", texts, want)
+	}
+}
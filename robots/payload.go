@@ -4,10 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
-	"os"
-	"strings"
 )
 
 type SlashCommand struct {
@@ -30,7 +27,9 @@ type Payload struct {
 	ResponseUrl string  `schema:"response_url,omitempty"`
 	BotID       string  `schema:"bot_id,omitempty"`
 	BotName     string  `schema:"bot_name,omitempty"`
-	Robot       string
+	// ThreadTimestamp - thread the robot was triggered in (empty at channel top level)
+	ThreadTimestamp string `schema:"thread_ts,omitempty"`
+	Robot           string
 }
 
 type OutgoingWebHook struct {
@@ -60,80 +59,98 @@ type Message struct {
 	IconEmoji   string       `json:"icon_emoji,omitempty"`
 	IconURL     string       `json:"icon_url,omitempty"`
 	Attachments []Attachment `json:"attachments,omitempty"`
-	UnfurlLinks bool         `json:"unfurl_links,omitempty"`
-	Parse       ParseStyle   `json:"parse,omitempty"`
-	LinkNames   bool         `json:"link_names,omitempty"`
-	Markdown    bool         `json:"mrkdwn,omitempty"`
+	// Blocks - Block Kit layout (see NewBlocks). Without Text the text fallback is made from blocks
+	Blocks      []Block    `json:"blocks,omitempty"`
+	UnfurlLinks bool       `json:"unfurl_links,omitempty"`
+	Parse       ParseStyle `json:"parse,omitempty"`
+	LinkNames   bool       `json:"link_names,omitempty"`
+	Markdown    bool       `json:"mrkdwn,omitempty"`
+	// ThreadTimestamp - reply in thread. With ReplyBroadcast the reply is shown in channel too
+	ThreadTimestamp string `json:"thread_ts,omitempty"`
+	ReplyBroadcast  bool   `json:"reply_broadcast,omitempty"`
+	// ReplaceOriginal and DeleteOriginal - change original slash command response (response URL only)
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+	DeleteOriginal  bool `json:"delete_original,omitempty"`
 }
 
 type IncomingWebhook Message
 type SlashCommandResponse Message
 
-type Attachment struct {
-	Fallback   string            `json:"fallback"`
-	Pretext    string            `json:"pretext,omitempty"`
-	Text       string            `json:"text,omitempty"`
-	Color      string            `json:"color,omitempty"`
-	Fields     []AttachmentField `json:"fields,omitempty"`
-	MarkdownIn []MarkdownField   `json:"mrkdown_in,omitempty"`
-}
-
-type MarkdownField string
-
-var (
-	MarkdownFieldPretext  = MarkdownField("pretext")
-	MarkdownFieldText     = MarkdownField("text")
-	MarkdownFieldTitle    = MarkdownField("title")
-	MarkdownFieldFields   = MarkdownField("fields")
-	MarkdownFieldFallback = MarkdownField("fallback")
-)
-
-type AttachmentField struct {
-	Title string `json:"title"`
-	Value string `json:"value"`
-	Short bool   `json:"short,omitempty"`
-}
-
-// Send uses the IncomingWebhook API to post a message to a slack channel
-func (i IncomingWebhook) Send() error {
-	u := os.Getenv(fmt.Sprintf("%s_IN_URL", strings.ToUpper(i.Domain)))
-	if u == "" {
-		return fmt.Errorf("Slack Incoming Webhook URL not found for domain %s (check %s)", i.Domain, fmt.Sprintf("%s_IN_URL", strings.ToUpper(i.Domain)))
+// Send posts a message to a slack channel using sender configured for the domain (incoming webhook by default).
+// Message is queued - Wait on returned delivery for the result
+func (i IncomingWebhook) Send() *Delivery {
+	sender := SenderFor(i.Domain)
+	if w, ok := sender.(WebhookSender); ok && w.URL == "" {
+		err := fmt.Errorf("Slack Incoming Webhook URL not found for domain %s (check %s)", i.Domain, webhookEnv(i.Domain))
+		log.Println(err)
+		return failedDelivery(err)
 	}
-	return Message(i).sendToUrl(u)
+	return sender.Send(Message(i))
 }
 
-// Send a response to the ResponseUrl in the Payload
-func (r SlashCommandResponse) Send(p *Payload) error {
+// Send a response to the ResponseUrl in the Payload. Without ResponseUrl the response is posted
+// to the channel of the command with bot token. Response is queued - Wait on returned delivery for the result.
+// SLACKBOT_SENDER doesn't apply when there is a ResponseUrl: only response URL responses are visible to the
+// command user alone and can be replaced later - chat.postMessage would show them to the whole channel
+func (r SlashCommandResponse) Send(p *Payload) *Delivery {
+	if r.ThreadTimestamp == "" {
+		r.ThreadTimestamp = p.ThreadTimestamp
+	}
 	if p.ResponseUrl == "" {
-		return fmt.Errorf("Empty ResponseUrl in Payload: %v", p)
+		if r.Channel == "" {
+			r.Channel = p.ChannelID
+		}
+		return APISender{Token: BotToken()}.Send(Message(r))
 	}
-	return Message(r).sendToUrl(p.ResponseUrl)
+	return ResponseSender{URL: p.ResponseUrl}.Send(Message(r))
 }
 
-func (i Message) sendToUrl(u string) error {
+func (i Message) sendToUrl(u string) *Delivery {
 	if u == "" {
-		return fmt.Errorf("Empty URL")
+		return failedDelivery(fmt.Errorf("Empty URL"))
 	}
-	webhook, err := url.Parse(u)
-	if err != nil {
+	if _, err := url.Parse(u); err != nil {
 		log.Printf("Error parsing URL \"%s\": %v", u, err)
-		return err
+		return failedDelivery(err)
 	}
 
+	i, err := i.prepare()
+	if err != nil {
+		log.Printf("Invalid message: %v", err)
+		return failedDelivery(err)
+	}
 	p, err := json.Marshal(i)
 	if err != nil {
-		return err
+		return failedDelivery(err)
 	}
+	return Outbox.Enqueue(u, p)
+}
 
-	data := url.Values{}
-	data.Set("payload", string(p))
+// prepare - validate blocks and attachments and add text fallbacks. Slack uses the fallbacks
+// in notifications and clients which can't show blocks
+func (i Message) prepare() (Message, error) {
+	if len(i.Attachments) > maxAttachments {
+		return i, fmt.Errorf("message has %d attachments, max %d", len(i.Attachments), maxAttachments)
+	}
+	if len(i.Attachments) > 0 {
+		attachments := make([]Attachment, len(i.Attachments))
+		for n, a := range i.Attachments {
+			if err := a.Validate(); err != nil {
+				return i, fmt.Errorf("attachment %d: %v", n+1, err)
+			}
+			attachments[n] = a.withFallback()
+		}
+		i.Attachments = attachments
+	}
 
-	webhook.RawQuery = data.Encode()
-	resp, err := http.PostForm(webhook.String(), data)
-	if resp.StatusCode != 200 {
-		message := fmt.Sprintf("ERROR: Non-200 Response from Slack URL \"%s\": %s", u, resp.Status)
-		log.Println(message)
+	if len(i.Blocks) == 0 {
+		return i, nil
+	}
+	if err := ValidateBlocks(i.Blocks); err != nil {
+		return i, err
+	}
+	if i.Text == "" {
+		i.Text = BlocksText(i.Blocks)
 	}
-	return err
+	return i, nil
 }
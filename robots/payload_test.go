@@ -0,0 +1,38 @@
+package robots
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSlashCommandResponseSender(t *testing.T) {
+	f := withFakeSlack(t)
+	t.Setenv("SLACKBOT_SENDER", SenderAPI)
+	var responses int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&responses, 1)
+	}))
+	defer srv.Close()
+
+	// Response URL keeps the response visible to the command user only, also in api mode
+	p := &Payload{ChannelID: "C0123ABCD", UserID: "U0123ABCD", ResponseUrl: srv.URL + "/response"}
+	response := SlashCommandResponse{Text: "private"}
+	if err := response.Send(p).Wait(); err != nil {
+		t.Fatalf("Send with response URL: %v", err)
+	}
+	if n := atomic.LoadInt32(&responses); n != 1 || len(f.posts) != 0 {
+		t.Errorf("response URL calls %d, chat.postMessage calls %d - want 1 and 0", n, len(f.posts))
+	}
+
+	// Without response URL (RTM and Events API triggers) it is posted to the channel
+	p.ResponseUrl = ""
+	response.Text = "public"
+	if err := response.Send(p).Wait(); err != nil {
+		t.Fatalf("Send without response URL: %v", err)
+	}
+	if len(f.posts) != 1 || f.posts[0].Channel != "C0123ABCD" {
+		t.Errorf("chat.postMessage calls %+v, want one to C0123ABCD", f.posts)
+	}
+}
@@ -3,6 +3,7 @@ package robots
 import (
 	"fmt"
 
+	"github.com/wojtekzw/slackbot/mrkdwn"
 	"github.com/wojtekzw/slackbot/robots"
 )
 
@@ -19,15 +20,9 @@ func (pb bot) Run(p *robots.Payload) (slashCommandImmediateReturn string) {
 }
 
 func (pb bot) DeferredAction(p *robots.Payload) {
-	response := &robots.IncomingWebhook{
-		Domain:      p.TeamDomain,
-		Channel:     p.ChannelID,
-		Username:    "Ping Bot",
-		Text:        fmt.Sprintf("@%s Pong!", p.UserName),
-		IconEmoji:   ":ghost:",
-		UnfurlLinks: true,
-		Parse:       robots.ParseStyleFull,
-	}
+	response := p.Reply("Ping Bot", fmt.Sprintf("%s Pong!", mrkdwn.User(p.UserID)))
+	response.IconEmoji = ":ghost:"
+	response.UnfurlLinks = true
 	response.Send()
 }
 
@@ -0,0 +1,310 @@
+package robots
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SenderWebhook = "webhook"
+	SenderAPI     = "api"
+)
+
+// slackAPIURL - Web API base URL
+var slackAPIURL = "https://slack.com/api/"
+
+// Sender - posts messages to Slack. Messages are queued in Outbox - Wait on delivery for the result.
+// Posted messages are changed with Update and Delete of the delivery
+type Sender interface {
+	Send(m Message) *Delivery
+	// Update - replace message posted with delivery d
+	Update(d *Delivery, m Message) *Delivery
+	// Delete - delete message posted with delivery d
+	Delete(d *Delivery) *Delivery
+}
+
+// WebhookSender - posts to incoming webhook. Slack doesn't return channel and timestamp
+// of the message, so it can't be updated or deleted
+type WebhookSender struct {
+	URL string
+}
+
+// Send - queue message to webhook URL. Long text is uploaded as snippet or split (SLACKBOT_LONG_MESSAGES)
+func (s WebhookSender) Send(m Message) *Delivery {
+	return sendMessage(m, s.send, 0)
+}
+
+func (s WebhookSender) send(m Message) *Delivery {
+	d := m.sendToUrl(s.URL)
+	d.sender = s
+	return d
+}
+
+// Update - not supported by incoming webhooks
+func (s WebhookSender) Update(d *Delivery, m Message) *Delivery {
+	return failedDelivery(fmt.Errorf("messages sent with incoming webhook can't be updated - use SLACKBOT_SENDER=api"))
+}
+
+// Delete - not supported by incoming webhooks
+func (s WebhookSender) Delete(d *Delivery) *Delivery {
+	return failedDelivery(fmt.Errorf("messages sent with incoming webhook can't be deleted - use SLACKBOT_SENDER=api"))
+}
+
+// ResponseSender - posts to slash command response URL. Response URL can replace and delete
+// the original response (up to 5 uses in total within 30 minutes of the command)
+type ResponseSender struct {
+	URL string
+}
+
+const (
+	// maxResponses - uses of one response URL
+	maxResponses = 5
+	// responseURLLifetime - response URL works this long after the command
+	responseURLLifetime = 30 * time.Minute
+)
+
+// responseUse - uses of one response URL
+type responseUse struct {
+	count int
+	first time.Time
+}
+
+// responseCounter - uses of response URLs by all senders, so parts, updates and deletes of one
+// command together stay within maxResponses
+type responseCounter struct {
+	mu   sync.Mutex
+	uses map[string]*responseUse
+}
+
+var responseURLs = &responseCounter{uses: make(map[string]*responseUse)}
+
+// left - uses of response URL left
+func (c *responseCounter) left(u string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	if use, ok := c.uses[u]; ok {
+		return maxResponses - use.count
+	}
+	return maxResponses
+}
+
+// take - count one use of response URL. False if it is used up
+func (c *responseCounter) take(u string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	use, ok := c.uses[u]
+	if !ok {
+		use = &responseUse{first: now}
+		c.uses[u] = use
+	}
+	if use.count >= maxResponses {
+		return false
+	}
+	use.count++
+	return true
+}
+
+// prune - forget expired response URLs. Caller must hold mu
+func (c *responseCounter) prune(now time.Time) {
+	for u, use := range c.uses {
+		if now.Sub(use.first) >= responseURLLifetime {
+			delete(c.uses, u)
+		}
+	}
+}
+
+// Send - queue message to response URL. Long text is split, parts over the uses left of the response URL are dropped
+func (s ResponseSender) Send(m Message) *Delivery {
+	left := responseURLs.left(s.URL, time.Now())
+	if left <= 0 {
+		return failedDelivery(fmt.Errorf("response URL used %d times - Slack accepts no more responses to the command", maxResponses))
+	}
+	return sendMessage(m, s.send, left)
+}
+
+func (s ResponseSender) send(m Message) *Delivery {
+	if !responseURLs.take(s.URL, time.Now()) {
+		return failedDelivery(fmt.Errorf("response URL used %d times - Slack accepts no more responses to the command", maxResponses))
+	}
+	d := m.sendToUrl(s.URL)
+	d.sender = s
+	return d
+}
+
+// Update - replace original response
+func (s ResponseSender) Update(d *Delivery, m Message) *Delivery {
+	m.ReplaceOriginal = true
+	return s.Send(m)
+}
+
+// Delete - delete original response
+func (s ResponseSender) Delete(d *Delivery) *Delivery {
+	return s.Send(Message{DeleteOriginal: true})
+}
+
+// APISender - posts with bot token using chat.postMessage. Delivery gets channel and timestamp of the message
+type APISender struct {
+	Token string
+}
+
+// Send - queue message to m.Channel (channel ID, or user ID for a direct message).
+// Long text is uploaded as snippet or split (SLACKBOT_LONG_MESSAGES)
+func (s APISender) Send(m Message) *Delivery {
+	if m.Channel == "" {
+		return failedDelivery(fmt.Errorf("chat.postMessage: no channel"))
+	}
+	return sendMessage(m, s.send, 0)
+}
+
+func (s APISender) send(m Message) *Delivery {
+	m, err := m.prepare()
+	if err != nil {
+		return failedDelivery(fmt.Errorf("chat.postMessage: %v", err))
+	}
+	return s.call("chat.postMessage", m.Channel, m)
+}
+
+// Update - replace message with chat.update
+func (s APISender) Update(d *Delivery, m Message) *Delivery {
+	m.Channel = d.Channel
+	m, err := m.prepare()
+	if err != nil {
+		return failedDelivery(fmt.Errorf("chat.update: %v", err))
+	}
+	return s.call("chat.update", d.Channel, struct {
+		Message
+		TS string `json:"ts"`
+	}{m, d.Timestamp})
+}
+
+// Delete - delete message with chat.delete
+func (s APISender) Delete(d *Delivery) *Delivery {
+	return s.call("chat.delete", d.Channel, struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{d.Channel, d.Timestamp})
+}
+
+// call - queue Web API call with JSON arguments. Calls about the same channel are made in order
+func (s APISender) call(method string, channel string, args interface{}) *Delivery {
+	if s.Token == "" {
+		return failedDelivery(fmt.Errorf("%s: no bot token (set SLACKBOT_BOT_TOKEN)", method))
+	}
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return failedDelivery(err)
+	}
+	return Outbox.enqueue(&Delivery{
+		URL:     slackAPIURL + method,
+		Payload: payload,
+		key:     "api:" + channel,
+		post:    s.post,
+		sender:  s,
+	})
+}
+
+// SendEphemeral - message visible only to user in channel (chat.postEphemeral). User must be in the channel.
+// Ephemeral messages can't be updated or deleted
+func (s APISender) SendEphemeral(channel string, user string, m Message) *Delivery {
+	if channel == "" || user == "" {
+		return failedDelivery(fmt.Errorf("chat.postEphemeral: no channel or user"))
+	}
+	m.Channel = channel
+	m, err := m.prepare()
+	if err != nil {
+		return failedDelivery(fmt.Errorf("chat.postEphemeral: %v", err))
+	}
+	d := s.call("chat.postEphemeral", channel, struct {
+		Message
+		User string `json:"user"`
+	}{m, user})
+	d.sender = nil
+	return d
+}
+
+// SendEphemeral - message visible only to user in channel, sent with bot token
+func SendEphemeral(channel string, user string, m Message) *Delivery {
+	return APISender{Token: BotToken()}.SendEphemeral(channel, user, m)
+}
+
+// apiResponse - common part of Web API responses
+type apiResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+	// MessageTS - chat.postEphemeral returns message_ts instead of ts
+	MessageTS string `json:"message_ts"`
+}
+
+// post - one Web API call. Slack reports most errors with HTTP 200 and ok=false
+func (s APISender) post(client *http.Client, d *Delivery) (retryAfter time.Duration, retry bool, err error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if retryAfter, retry, err := checkResponse(resp, body); err != nil {
+		return retryAfter, retry, err
+	}
+
+	var r apiResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return 0, false, fmt.Errorf("invalid response: %v", err)
+	}
+	if !r.OK {
+		switch r.Error {
+		case "ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+			return 0, true, fmt.Errorf("%s", r.Error)
+		}
+		return 0, false, fmt.Errorf("%s", r.Error)
+	}
+
+	d.Channel = r.Channel
+	d.Timestamp = r.TS
+	if d.Timestamp == "" {
+		d.Timestamp = r.MessageTS
+	}
+	return 0, false, nil
+}
+
+// BotToken - token for Web API sender: SLACKBOT_BOT_TOKEN, SLACKBOT_API_TOKEN if not set
+func BotToken() string {
+	if t := os.Getenv("SLACKBOT_BOT_TOKEN"); t != "" {
+		return t
+	}
+	return os.Getenv("SLACKBOT_API_TOKEN")
+}
+
+// SenderFor - sender configured with SLACKBOT_SENDER: "webhook" (default) posts to <DOMAIN>_IN_URL,
+// "api" posts with bot token. Without domain (e.g. from RTM) bot token is used - webhooks can't send DMs.
+// Slash command responses go to the response URL in both modes (see SlashCommandResponse.Send)
+func SenderFor(domain string) Sender {
+	mode := strings.ToLower(os.Getenv("SLACKBOT_SENDER"))
+	if mode == SenderAPI || domain == "" {
+		return APISender{Token: BotToken()}
+	}
+	return WebhookSender{URL: os.Getenv(webhookEnv(domain))}
+}
+
+// webhookEnv - name of environment variable with incoming webhook URL of team domain
+func webhookEnv(domain string) string {
+	return fmt.Sprintf("%s_IN_URL", strings.ToUpper(domain))
+}
@@ -0,0 +1,290 @@
+package robots
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Modes of sending messages over the length limit (SLACKBOT_LONG_MESSAGES)
+const (
+	// LongSnippet - upload text as snippet when possible (channel and bot token), split otherwise
+	LongSnippet = "snippet"
+	// LongMessages - split into several messages
+	LongMessages = "messages"
+	// LongThread - split, continuations are replies in thread of the first part (Web API only)
+	LongThread = "thread"
+)
+
+const (
+	// Slack truncates text over 40000 characters, but recommends up to 4000 for readability
+	defaultMaxMessageLength = 4000
+	// room for "(1/3)" marker and closing/reopening code block
+	splitReserve = 24
+	codeFence    = "```"
+)
+
+// MaxMessageLength - longer messages are split (SLACKBOT_MAX_MESSAGE_LENGTH characters)
+func MaxMessageLength() int {
+	return envInt("SLACKBOT_MAX_MESSAGE_LENGTH", defaultMaxMessageLength)
+}
+
+// LongMessageMode - how long messages are sent (SLACKBOT_LONG_MESSAGES: snippet, messages or thread)
+func LongMessageMode() string {
+	switch mode := os.Getenv("SLACKBOT_LONG_MESSAGES"); mode {
+	case LongMessages, LongThread, LongSnippet:
+		return mode
+	}
+	return LongSnippet
+}
+
+// Measure - characters of text and attachments shown by Slack
+func Measure(m Message) int {
+	n := utf8.RuneCountInString(m.Text)
+	for _, a := range m.Attachments {
+		n += attachmentLen(a)
+	}
+	return n
+}
+
+func attachmentLen(a Attachment) int {
+	n := 0
+	for _, s := range []string{a.Pretext, a.AuthorName, a.Title, a.Text, a.Footer} {
+		n += utf8.RuneCountInString(s)
+	}
+	for _, f := range a.Fields {
+		n += utf8.RuneCountInString(f.Title) + utf8.RuneCountInString(f.Value)
+	}
+	return n
+}
+
+// Split - message split into parts not longer than limit characters, marked "(1/3)", "(2/3)"...
+// Text is split at paragraph or line boundaries if possible, open code blocks are closed and reopened.
+// Attachments go with the last text part or to their own messages. Messages with blocks are not split
+func Split(m Message, limit int) []Message {
+	if Measure(m) <= limit || len(m.Blocks) > 0 || limit <= splitReserve {
+		return []Message{m}
+	}
+	return markParts(m, splitParts(m, limit))
+}
+
+// splitParts - parts of Split without "(1/3)" markers
+func splitParts(m Message, limit int) []Message {
+	var parts []Message
+	if m.Text != "" {
+		for _, text := range SplitText(m.Text, limit-splitReserve) {
+			part := continuation(m)
+			part.Text = text
+			parts = append(parts, part)
+		}
+	}
+
+	for _, group := range groupAttachments(m.Attachments, limit-splitReserve) {
+		size := 0
+		for _, a := range group {
+			size += attachmentLen(a)
+		}
+		last := len(parts) - 1
+		if last >= 0 && len(parts[last].Attachments) == 0 && utf8.RuneCountInString(parts[last].Text)+size <= limit-splitReserve {
+			parts[last].Attachments = group
+			continue
+		}
+		part := continuation(m)
+		part.Attachments = group
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// markParts - add "(1/3)" markers to parts of m
+func markParts(m Message, parts []Message) []Message {
+	// Only the first part replaces original response or is broadcast from thread
+	for i := range parts {
+		if i == 0 {
+			parts[i].ReplaceOriginal = m.ReplaceOriginal
+			parts[i].ReplyBroadcast = m.ReplyBroadcast
+		}
+		marker := fmt.Sprintf("(%d/%d)", i+1, len(parts))
+		if parts[i].Text == "" {
+			parts[i].Text = marker
+		} else {
+			parts[i].Text += "\n" + marker
+		}
+	}
+	return parts
+}
+
+// continuation - message with the same destination and sender settings, without content
+func continuation(m Message) Message {
+	m.Text = ""
+	m.Attachments = nil
+	m.ReplaceOriginal = false
+	m.ReplyBroadcast = false
+	return m
+}
+
+// SplitText - text split into parts not longer than limit characters. Cuts at paragraph,
+// then line, then word boundaries. Code blocks open at the cut are closed and reopened in the next part
+func SplitText(text string, limit int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		cut := cutPoint(text, limit-len(codeFence)-1)
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	if text != "" || len(parts) == 0 {
+		parts = append(parts, text)
+	}
+
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.Count(parts[i], codeFence)%2 == 1 {
+			parts[i] += "\n" + codeFence
+			parts[i+1] = codeFence + "\n" + parts[i+1]
+		}
+	}
+	return parts
+}
+
+// cutPoint - byte index to cut text at, at most limit characters. Paragraph or line boundary
+// is used if it keeps at least half of the limit, then space, then the limit itself
+func cutPoint(text string, limit int) int {
+	if limit < 1 {
+		limit = 1
+	}
+	end, n := len(text), 0
+	for i := range text {
+		if n == limit {
+			end = i
+			break
+		}
+		n++
+	}
+	prefix := text[:end]
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(prefix, sep); i > 0 && i >= len(prefix)/2 {
+			return i + len(sep)
+		}
+	}
+	return end
+}
+
+// groupAttachments - attachments in groups not longer than limit characters (and Slack count limit).
+// Attachment with text over the limit is split into several attachments
+func groupAttachments(attachments []Attachment, limit int) [][]Attachment {
+	var groups [][]Attachment
+	var group []Attachment
+	size := 0
+	for _, a := range attachments {
+		for _, piece := range splitAttachment(a, limit) {
+			n := attachmentLen(piece)
+			if len(group) > 0 && (size+n > limit || len(group) == maxAttachments) {
+				groups = append(groups, group)
+				group, size = nil, 0
+			}
+			group = append(group, piece)
+			size += n
+		}
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// splitAttachment - attachment with long text as several attachments. Header (pretext, author, title)
+// stays in the first one, fields, footer, image and actions in the last one
+func splitAttachment(a Attachment, limit int) []Attachment {
+	if attachmentLen(a) <= limit || a.Text == "" {
+		return []Attachment{a}
+	}
+	texts := SplitText(a.Text, limit/2)
+	if len(texts) == 1 {
+		return []Attachment{a}
+	}
+
+	pieces := make([]Attachment, len(texts))
+	for i, text := range texts {
+		switch i {
+		case 0:
+			first := a
+			first.Fields, first.ImageURL, first.ThumbURL, first.Actions = nil, "", "", nil
+			first.Footer, first.FooterIcon, first.Timestamp = "", "", 0
+			pieces[i] = first
+		case len(texts) - 1:
+			last := a
+			last.Pretext, last.AuthorName, last.AuthorLink, last.AuthorIcon = "", "", "", ""
+			last.Title, last.TitleLink = "", ""
+			pieces[i] = last
+		default:
+			pieces[i] = Attachment{Color: a.Color, MarkdownIn: a.MarkdownIn}
+		}
+		pieces[i].Text = text
+		pieces[i].Fallback = ""
+	}
+	return pieces
+}
+
+// sendMessage - send message with send, long one as snippet or in parts depending on LongMessageMode.
+// If destination takes at most maxParts messages (0 - no limit), the rest is dropped with a note
+func sendMessage(m Message, send func(Message) *Delivery, maxParts int) *Delivery {
+	mode := LongMessageMode()
+	if mode == LongSnippet && isLong(m) {
+		return sendAsSnippet(m, send)
+	}
+	parts := Split(m, MaxMessageLength())
+	if len(parts) == 1 {
+		return send(m)
+	}
+	if maxParts > 0 && len(parts) > maxParts {
+		parts = truncateParts(m, maxParts)
+	}
+	return sendParts(parts, mode == LongThread, send)
+}
+
+// truncateParts - first maxParts parts of long message, the last one ends with a truncation note
+func truncateParts(m Message, maxParts int) []Message {
+	all := splitParts(m, MaxMessageLength())
+	log.Printf("Message in %d parts, only %d can be sent - rest dropped", len(all), maxParts)
+	parts := all[:maxParts]
+	last := &parts[maxParts-1]
+	if last.Text != "" {
+		last.Text += "\n"
+	}
+	last.Text += fmt.Sprintf("_(%d more part(s) not sent - Slack allows %d responses to a command)_", len(all)-maxParts, maxResponses)
+	return markParts(m, parts)
+}
+
+// sendParts - send parts in order. In thread mode parts after the first are replies to it
+// (if the first is not in a thread already and sender knows its timestamp - Web API).
+// Returned delivery is the first part's - Update and Delete change the first part only
+func sendParts(parts []Message, thread bool, send func(Message) *Delivery) *Delivery {
+	d := &Delivery{done: make(chan struct{})}
+	first := send(parts[0])
+	go func() {
+		err := first.Wait()
+		d.URL, d.Payload, d.Attempts = first.URL, first.Payload, first.Attempts
+		d.Channel, d.Timestamp, d.sender = first.Channel, first.Timestamp, first.sender
+		if err != nil {
+			d.finish(err)
+			return
+		}
+
+		var rest []*Delivery
+		for _, p := range parts[1:] {
+			if thread && p.ThreadTimestamp == "" && first.Timestamp != "" {
+				// Channel of a DM is known only after the first part is posted
+				p.Channel, p.ThreadTimestamp = first.Channel, first.Timestamp
+			}
+			rest = append(rest, send(p))
+		}
+		for _, r := range rest {
+			if e := r.Wait(); e != nil && err == nil {
+				err = e
+			}
+		}
+		d.finish(err)
+	}()
+	return d
+}
@@ -0,0 +1,177 @@
+package robots
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	code := "```\n" + strings.Repeat("line of code\n", 6) + "```"
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "hello", 10, []string{"hello"}},
+		{"empty", "", 10, []string{""}},
+		{"exactly at limit", strings.Repeat("a", 10), 10, []string{strings.Repeat("a", 10)}},
+		{"one over limit", strings.Repeat("a", 11), 10, []string{"aaaaaa", "aaaaa"}},
+		{"multibyte counted as characters", strings.Repeat("ż", 10), 10, []string{strings.Repeat("ż", 10)}},
+		{"cut at paragraph", "first para\n\nsecond para", 16, []string{"first para", "second para"}},
+		{"cut at line", "first line\nsecond line", 16, []string{"first line", "second line"}},
+		{"cut at word", "alpha beta gamma", 12, []string{"alpha", "beta gamma"}},
+		{"code block reopened", code, 40, []string{
+			"```\nline of code\nline of code\n```",
+			"```\nline of code\nline of code\n```",
+			"```\nline of code\nline of code\n```",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitText(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			for i, part := range got {
+				if n := utf8.RuneCountInString(part); n > tt.limit {
+					t.Errorf("part %d has %d characters, limit %d", i+1, n, tt.limit)
+				}
+				if strings.Count(part, codeFence)%2 != 0 {
+					t.Errorf("part %d has unclosed code block: %q", i+1, part)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitMarkers(t *testing.T) {
+	m := Message{Channel: "C0123ABCD", Text: strings.Repeat("word ", 40), ReplaceOriginal: true, ReplyBroadcast: true}
+
+	if parts := Split(m, Measure(m)); len(parts) != 1 || parts[0].Text != m.Text {
+		t.Errorf("message exactly at limit was split: %+v", parts)
+	}
+
+	parts := Split(m, 100)
+	if len(parts) < 2 {
+		t.Fatalf("Split into %d part(s), want more", len(parts))
+	}
+	for i, p := range parts {
+		marker := fmt.Sprintf("\n(%d/%d)", i+1, len(parts))
+		if !strings.HasSuffix(p.Text, marker) {
+			t.Errorf("part %d = %q, want %q marker", i+1, p.Text, marker)
+		}
+		if n := utf8.RuneCountInString(p.Text); n > 100 {
+			t.Errorf("part %d has %d characters, limit 100", i+1, n)
+		}
+		if p.Channel != m.Channel || p.ReplaceOriginal != (i == 0) || p.ReplyBroadcast != (i == 0) {
+			t.Errorf("part %d settings: channel %q, replace %t, broadcast %t", i+1, p.Channel, p.ReplaceOriginal, p.ReplyBroadcast)
+		}
+	}
+}
+
+func TestResponseSenderPartsLimit(t *testing.T) {
+	var mu sync.Mutex
+	var posts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts = append(posts, r.FormValue("payload"))
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	os.Setenv("SLACKBOT_MAX_MESSAGE_LENGTH", "100")
+	defer os.Unsetenv("SLACKBOT_MAX_MESSAGE_LENGTH")
+
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("command %d - description", i))
+	}
+	if err := (ResponseSender{URL: srv.URL}).Send(Message{Text: strings.Join(lines, "\n")}).Wait(); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(posts) != maxResponses {
+		t.Fatalf("%d responses posted, response URL takes %d", len(posts), maxResponses)
+	}
+	last := posts[len(posts)-1]
+	if !strings.Contains(last, "more part(s) not sent") || !strings.Contains(last, "(5/5)") {
+		t.Errorf("last response has no truncation note: %s", last)
+	}
+}
+
+func TestResponseURLUsesCounted(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts++
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	os.Setenv("SLACKBOT_MAX_MESSAGE_LENGTH", "100")
+	defer os.Unsetenv("SLACKBOT_MAX_MESSAGE_LENGTH")
+
+	s := ResponseSender{URL: srv.URL + "/commands/1"}
+	// 3 parts, then update and delete use the rest
+	d := s.Send(Message{Text: strings.Repeat("word ", 30)})
+	if err := d.Wait(); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	mu.Lock()
+	if posts != 3 {
+		t.Fatalf("message sent in %d parts, want 3", posts)
+	}
+	mu.Unlock()
+	if err := s.Update(d, Message{Text: "done"}).Wait(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := s.Delete(d).Wait(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := (ResponseSender{URL: s.URL}).Send(Message{Text: "again"}).Wait(); err == nil {
+		t.Error("sixth use of response URL accepted")
+	}
+	mu.Lock()
+	if posts != maxResponses {
+		t.Errorf("%d posts to response URL, want %d", posts, maxResponses)
+	}
+	posts = 0
+	mu.Unlock()
+
+	// Long message gets only the uses left
+	other := ResponseSender{URL: srv.URL + "/commands/2"}
+	for i := 0; i < 3; i++ {
+		other.Send(Message{Text: "progress"}).Wait()
+	}
+	other.Send(Message{Text: strings.Repeat("word ", 50)}).Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 3+2 {
+		t.Errorf("long message sent in %d parts with 2 uses left", posts)
+	}
+}
+
+func TestResponseCounterExpires(t *testing.T) {
+	c := &responseCounter{uses: make(map[string]*responseUse)}
+	start := time.Now()
+	for i := 0; i < maxResponses; i++ {
+		if !c.take("u", start) {
+			t.Fatalf("use %d refused", i+1)
+		}
+	}
+	if c.take("u", start.Add(time.Minute)) || c.left("u", start) != 0 {
+		t.Error("used up response URL accepted")
+	}
+	if c.left("u", start.Add(responseURLLifetime)) != maxResponses || len(c.uses) != 0 {
+		t.Error("expired response URL not forgotten")
+	}
+}
@@ -0,0 +1,62 @@
+package robots
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+// NewPayload - payload of robot triggered by a message from RTM or Events API. Replies go to the thread
+// of the message, or to the channel if the message is at top level
+func NewPayload(msg *slack.Msg, teamDomain string, robot string) *Payload {
+	ts, _ := strconv.ParseFloat(msg.Timestamp, 64)
+	return &Payload{
+		TeamID:          msg.Team,
+		TeamDomain:      teamDomain,
+		ChannelID:       msg.Channel,
+		Timestamp:       ts,
+		UserID:          msg.User,
+		UserName:        msg.Username,
+		Text:            msg.Text,
+		BotID:           msg.BotID,
+		ThreadTimestamp: msg.ThreadTimestamp,
+		Robot:           robot,
+	}
+}
+
+// Reply - webhook message to the channel and thread the robot was triggered in.
+// Set ReplyBroadcast to show a thread reply in the channel too
+func (p *Payload) Reply(username string, text string) *IncomingWebhook {
+	return &IncomingWebhook{
+		Domain:          p.TeamDomain,
+		Channel:         p.ChannelID,
+		Username:        username,
+		Text:            text,
+		ThreadTimestamp: p.ThreadTimestamp,
+	}
+}
+
+// Thread - messages of the thread the robot was triggered in, parent first, up to the triggering message
+// (replies posted later are left out). Needs bot token
+func (p *Payload) Thread() ([]slack.Message, error) {
+	if p.ThreadTimestamp == "" {
+		return nil, nil
+	}
+	token := BotToken()
+	if token == "" {
+		return nil, fmt.Errorf("no bot token (set SLACKBOT_BOT_TOKEN)")
+	}
+	messages, err := utils.GetThread(slack.New(token, slack.OptionAPIURL(slackAPIURL)), p.ChannelID, p.ThreadTimestamp)
+	if err != nil || p.Timestamp == 0 {
+		return messages, err
+	}
+	var earlier []slack.Message
+	for _, m := range messages {
+		if ts, _ := strconv.ParseFloat(m.Timestamp, 64); ts <= p.Timestamp {
+			earlier = append(earlier, m)
+		}
+	}
+	return earlier, nil
+}
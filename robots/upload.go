@@ -0,0 +1,157 @@
+package robots
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	defaultSnippetThreshold = 4000
+	snippetPreviewLen       = 300
+)
+
+// Upload - file robot sends to Slack, e.g. CSV export, PNG chart or long text output
+type Upload struct {
+	Content  io.Reader
+	Filename string
+	Title    string
+	// Filetype - Slack file type (csv, png, text...), detected by Slack if empty
+	Filetype string
+	// Comment - message posted with the file
+	Comment         string
+	Channels        []string
+	ThreadTimestamp string
+}
+
+// UploadFile - upload file with files.upload using bot token. Waits out rate limits
+func UploadFile(u Upload) (*slack.File, error) {
+	token := BotToken()
+	if token == "" {
+		return nil, fmt.Errorf("files.upload: no bot token (set SLACKBOT_BOT_TOKEN)")
+	}
+	if u.Content == nil {
+		return nil, fmt.Errorf("files.upload: no content")
+	}
+
+	// Content is read once, so upload can be retried
+	content, err := ioutil.ReadAll(u.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	api := slack.New(token, slack.OptionAPIURL(slackAPIURL))
+	var file *slack.File
+	err = utils.RetryRateLimited("files.upload", func() (err error) {
+		file, err = api.UploadFile(slack.FileUploadParameters{
+			Reader:          bytes.NewReader(content),
+			Filename:        u.Filename,
+			Filetype:        u.Filetype,
+			Title:           u.Title,
+			InitialComment:  u.Comment,
+			Channels:        u.Channels,
+			ThreadTimestamp: u.ThreadTimestamp,
+		})
+		return err
+	})
+	return file, err
+}
+
+// DeleteFile - delete file with files.delete using bot token. Waits out rate limits
+func DeleteFile(id string) error {
+	token := BotToken()
+	if token == "" {
+		return fmt.Errorf("files.delete: no bot token (set SLACKBOT_BOT_TOKEN)")
+	}
+	api := slack.New(token, slack.OptionAPIURL(slackAPIURL))
+	return utils.RetryRateLimited("files.delete", func() error {
+		return api.DeleteFile(id)
+	})
+}
+
+// SnippetThreshold - text longer than this (SLACKBOT_SNIPPET_THRESHOLD characters) is sent as snippet
+func SnippetThreshold() int {
+	return envInt("SLACKBOT_SNIPPET_THRESHOLD", defaultSnippetThreshold)
+}
+
+// isLong - message text should be sent as snippet. Only messages to a channel (not to user ID)
+// with bot token available are uploaded - snippets are visible to everyone in the channel
+func isLong(m Message) bool {
+	if m.Channel == "" || len(m.Blocks) > 0 || strings.HasPrefix(m.Channel, "U") || strings.HasPrefix(m.Channel, "W") {
+		return false
+	}
+	return BotToken() != "" && utf8.RuneCountInString(m.Text) > SnippetThreshold()
+}
+
+// sendAsSnippet - upload message text as snippet with beginning of the text as comment.
+// Attachments can't go with a file - they are sent with send as a follow-up message.
+// Delivery has FileID of the snippet; it can be deleted, not updated
+func sendAsSnippet(m Message, send func(Message) *Delivery) *Delivery {
+	d := &Delivery{done: make(chan struct{})}
+	go func() {
+		title := m.Username
+		if title == "" {
+			title = "Output"
+		}
+		file, err := UploadFile(Upload{
+			Content:         strings.NewReader(m.Text),
+			Filename:        "output.txt",
+			Title:           title,
+			Filetype:        "text",
+			Comment:         preview(m.Text) + "\n_(full output in the snippet)_",
+			Channels:        []string{m.Channel},
+			ThreadTimestamp: m.ThreadTimestamp,
+		})
+		d.Channel = m.Channel
+		if err != nil {
+			log.Printf("Error uploading snippet to %s: %v", m.Channel, err)
+			d.finish(err)
+			return
+		}
+		d.FileID = file.ID
+
+		if len(m.Attachments) > 0 {
+			rest := continuation(m)
+			rest.Attachments = m.Attachments
+			d.followUp = sendMessage(rest, send, 0)
+			if err = d.followUp.Wait(); err != nil {
+				log.Printf("Error sending attachments of snippet %s: %v", file.ID, err)
+			}
+		}
+		d.finish(err)
+	}()
+	return d
+}
+
+// deleteSnippet - delete snippet file of delivery d and its follow-up message with attachments
+func deleteSnippet(d *Delivery) *Delivery {
+	res := &Delivery{Channel: d.Channel, done: make(chan struct{})}
+	go func() {
+		err := DeleteFile(d.FileID)
+		if err == nil && d.followUp != nil {
+			err = d.followUp.Delete().Wait()
+		}
+		res.finish(err)
+	}()
+	return res
+}
+
+// preview - first lines of text, at most snippetPreviewLen characters
+func preview(text string) string {
+	r := []rune(text)
+	if len(r) <= snippetPreviewLen {
+		return text
+	}
+	cut := string(r[:snippetPreviewLen])
+	if i := strings.LastIndex(cut, "\n"); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
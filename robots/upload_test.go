@@ -0,0 +1,98 @@
+package robots
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSlack - Web API server recording called methods with their JSON or form arguments
+type fakeSlack struct {
+	mu    sync.Mutex
+	calls []string
+	posts []Message
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	if method == "auth.test" {
+		// nlopes/slack checks the token before some calls
+		w.Write([]byte(`{"ok":true}`))
+		return
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, method)
+	f.mu.Unlock()
+
+	switch method {
+	case "files.upload":
+		w.Write([]byte(`{"ok":true,"file":{"id":"F0123ABCD"}}`))
+	case "chat.postMessage":
+		var m Message
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &m)
+		f.mu.Lock()
+		f.posts = append(f.posts, m)
+		f.mu.Unlock()
+		w.Write([]byte(`{"ok":true,"channel":"` + m.Channel + `","ts":"1500000000.000100"}`))
+	case "conversations.replies":
+		w.Write([]byte(`{"ok":true,"has_more":false,"messages":[
+			{"type":"message","ts":"1500000000.000100","thread_ts":"1500000000.000100","text":"parent"},
+			{"type":"message","ts":"1500000001.000100","thread_ts":"1500000000.000100","text":"trigger"},
+			{"type":"message","ts":"1500000002.000100","thread_ts":"1500000000.000100","text":"later"}]}`))
+	default:
+		w.Write([]byte(`{"ok":true}`))
+	}
+}
+
+// withFakeSlack - point Web API calls to fake server with bot token set
+func withFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{}
+	srv := httptest.NewServer(f)
+	old := slackAPIURL
+	slackAPIURL = srv.URL + "/"
+	os.Setenv("SLACKBOT_BOT_TOKEN", "xoxb-test")
+	t.Cleanup(func() {
+		slackAPIURL = old
+		os.Unsetenv("SLACKBOT_BOT_TOKEN")
+		srv.Close()
+	})
+	return f
+}
+
+func TestSnippetKeepsAttachmentsAndFile(t *testing.T) {
+	f := withFakeSlack(t)
+	os.Setenv("SLACKBOT_SNIPPET_THRESHOLD", "10")
+	defer os.Unsetenv("SLACKBOT_SNIPPET_THRESHOLD")
+
+	d := APISender{Token: BotToken()}.Send(Message{
+		Channel:     "C0123ABCD",
+		Text:        strings.Repeat("long output ", 10),
+		Attachments: []Attachment{{Text: "summary", Color: ColorGood}},
+	})
+	if err := d.Wait(); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if d.FileID != "F0123ABCD" {
+		t.Errorf("FileID = %q, want F0123ABCD", d.FileID)
+	}
+	if len(f.posts) != 1 || len(f.posts[0].Attachments) != 1 || f.posts[0].Attachments[0].Text != "summary" {
+		t.Fatalf("attachments not sent after snippet: %+v", f.posts)
+	}
+
+	if err := d.Update(Message{Text: "new"}).Wait(); err == nil || !strings.Contains(err.Error(), "snippet") {
+		t.Errorf("Update of snippet = %v, want snippet can't be edited error", err)
+	}
+	if err := d.Delete().Wait(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := []string{"files.upload", "chat.postMessage", "files.delete", "chat.delete"}
+	if strings.Join(f.calls, " ") != strings.Join(want, " ") {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+}
@@ -0,0 +1,96 @@
+package robots
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
+	"github.com/wojtekzw/slackbot/rtm"
+)
+
+type bot struct {
+	presence *rtm.PresenceTracker
+	config   *rtm.BlockConfig
+}
+
+func init() {
+	r := &bot{presence: rtm.Presence, config: rtm.Config}
+	robots.RegisterRobot("whoisin", r)
+}
+
+func (r bot) Run(p *robots.Payload) (slashCommandImmediateReturn string) {
+	args := mrkdwn.Fields(p.Text)
+	if len(args) > 1 {
+		return "Usage: /whoisin [@group|#channel]"
+	}
+
+	// Current channel by default
+	ref := p.ChannelID
+	title := mrkdwn.Channel(p.ChannelID)
+	if len(args) == 1 {
+		ref = args[0]
+		title = mrkdwn.Escape(mrkdwn.PlainText(mrkdwn.Parse(args[0])))
+	}
+
+	// Members of a channel can be fetched from Slack - answer after slash command returns
+	go r.DeferredAction(p, ref, title)
+	return ""
+}
+
+// DeferredAction - send presence of members of ref
+func (r bot) DeferredAction(p *robots.Payload, ref string, title string) {
+	response := &robots.SlashCommandResponse{Text: r.report(ref, title)}
+	if err := response.Send(p).Wait(); err != nil {
+		log.Printf("Error sending whoisin result: %v", err)
+	}
+}
+
+// report - who is active or away in ref, active first
+func (r bot) report(ref string, title string) string {
+	list, err := r.presence.WhoIsIn(ref)
+	if err != nil {
+		return "whoisin: " + mrkdwn.Escape(err.Error())
+	}
+	if len(list) == 0 {
+		return fmt.Sprintf("whoisin: Nobody in %s", title)
+	}
+
+	active := 0
+	var lines []string
+	for _, u := range list {
+		if u.Presence == "active" {
+			active++
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", mrkdwn.Escape("@"+r.config.UserName(u.UserID)), r.status(u)))
+	}
+	header := fmt.Sprintf("%s %s: %d of %d active", mrkdwn.Bold("Who is in"), title, active, len(list))
+	return header + "\n" + strings.Join(lines, "\n")
+}
+
+// status - presence with last seen time, e.g. "away, last seen 14:05 (2h ago)"
+func (r bot) status(u rtm.UserPresence) string {
+	switch {
+	case u.Presence == "active":
+		return "active"
+	case u.LastSeen.IsZero():
+		presence := u.Presence
+		if presence == "" {
+			presence = "unknown"
+		}
+		return fmt.Sprintf("%s, not seen since %s", presence, formatSeen(r.presence.Started()))
+	}
+	return fmt.Sprintf("%s, last seen %s", u.Presence, formatSeen(u.LastSeen))
+}
+
+// formatSeen - time in reader's timezone, e.g. "today 14:05 (2h0m0s ago)"
+func formatSeen(t time.Time) string {
+	ago := time.Since(t).Round(time.Minute)
+	return fmt.Sprintf("%s (%s ago)", mrkdwn.Date(t, mrkdwn.DateShortPretty+" "+mrkdwn.Time), ago)
+}
+
+func (r bot) Description() (description string) {
+	return "Who is active or away\n\tUsage: /whoisin [@group|#channel]"
+}
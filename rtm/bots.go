@@ -0,0 +1,341 @@
+package rtm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+// Kinds of bot allow-list entries
+const (
+	BotKindID   = "bot"  // bot_id from the message (B...)
+	BotKindApp  = "app"  // app ID the bot belongs to (A...)
+	BotKindName = "name" // username the bot posts as
+)
+
+// BotEntry - bot allow-list entry. Written as "bot:B0123", "app:A0123" or "name:jenkins" (plain "jenkins" is a name)
+type BotEntry struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+func (e BotEntry) String() string {
+	return e.Kind + ":" + e.Value
+}
+
+// ParseBotEntry - parse allow-list entry written as kind:value
+func ParseBotEntry(s string) (BotEntry, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		return BotEntry{Kind: BotKindName, Value: s}, nil
+	}
+	kind, value := strings.ToLower(parts[0]), parts[1]
+	if value == "" {
+		return BotEntry{}, fmt.Errorf("empty bot entry '%s'", s)
+	}
+	switch kind {
+	case BotKindID, BotKindApp:
+		return BotEntry{Kind: kind, Value: strings.ToUpper(value)}, nil
+	case BotKindName:
+		return BotEntry{Kind: kind, Value: value}, nil
+	}
+	return BotEntry{}, fmt.Errorf("unknown bot entry kind '%s' - use bot:, app: or name:", kind)
+}
+
+// SeenBot - bot which posted recently, reported by /block bots
+type SeenBot struct {
+	BotID    string
+	AppID    string
+	Username string
+	Messages int
+	LastSeen time.Time
+}
+
+const (
+	// failed bots.info lookups are retried after this time
+	botInfoRetryAfter = 10 * time.Minute
+	// botAppsFile - app IDs of bots looked up with bots.info, kept so restarts don't need the lookups again
+	botAppsFile = "bot_apps.json"
+)
+
+// slackAPIURL - Web API base URL of bots.info, changed in tests
+var slackAPIURL = slack.APIURL
+
+// botRegistry - bots seen in RTM messages with cached app IDs
+type botRegistry struct {
+	mu     sync.Mutex
+	seen   map[string]*SeenBot
+	appIDs map[string]*appIDEntry
+	loaded bool
+}
+
+// appIDEntry - app ID of a bot. Failed lookups are kept until retryAt so failing bots don't cause
+// bots.info call for every message. done is closed when lookup in progress ends
+type appIDEntry struct {
+	id      string
+	done    chan struct{}
+	failed  bool
+	retryAt time.Time
+}
+
+var bots = newBotRegistry()
+
+func newBotRegistry() *botRegistry {
+	return &botRegistry{
+		seen:   make(map[string]*SeenBot),
+		appIDs: make(map[string]*appIDEntry),
+	}
+}
+
+// record - remember bot message author. App ID of a new bot is looked up in background for /block bots
+func (r *botRegistry) record(msg *slack.Msg) {
+	if msg.BotID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.seen[msg.BotID]
+	if !ok {
+		b = &SeenBot{BotID: msg.BotID}
+		r.seen[msg.BotID] = b
+		if _, known := r.appIDs[msg.BotID]; !known {
+			go r.appID(msg.BotID)
+		}
+	}
+	if msg.Username != "" {
+		b.Username = msg.Username
+	}
+	b.Messages++
+	b.LastSeen = time.Now()
+}
+
+// recent - bots seen since given time, most recent first
+func (r *botRegistry) recent(since time.Time) []SeenBot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []SeenBot
+	for _, b := range r.seen {
+		if b.LastSeen.After(since) {
+			bot := *b
+			if e, ok := r.appIDs[b.BotID]; ok {
+				bot.AppID = e.id
+			}
+			list = append(list, bot)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+// appID - app ID of the bot. Unknown bots are looked up with bots.info and the caller waits for the result,
+// so the first message of an allow-listed app isn't moderated before its app ID is known.
+// Empty ID is returned while a failed lookup waits for retry
+func (r *botRegistry) appID(botID string) string {
+	r.mu.Lock()
+	r.load()
+	e, ok := r.appIDs[botID]
+	if ok && e.done == nil && (!e.failed || time.Now().Before(e.retryAt)) {
+		r.mu.Unlock()
+		return e.id
+	}
+	if !ok || e.done == nil {
+		if !ok {
+			e = &appIDEntry{}
+			r.appIDs[botID] = e
+		}
+		e.done = make(chan struct{})
+		go r.lookup(botID, e)
+	}
+	done := e.done
+	r.mu.Unlock()
+
+	<-done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return e.id
+}
+
+// lookup - get app ID of the bot and cache the result, failures for botInfoRetryAfter
+func (r *botRegistry) lookup(botID string, e *appIDEntry) {
+	id, err := lookupBotAppID(botID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(e.done)
+	e.done = nil
+	if err != nil {
+		log.Printf("Error getting app ID of bot %s (retry in %s): %v", botID, botInfoRetryAfter, err)
+		e.failed = true
+		e.retryAt = time.Now().Add(botInfoRetryAfter)
+		return
+	}
+	e.id, e.failed = id, false
+	r.save()
+}
+
+// load - read app IDs saved by earlier runs, once. Caller must hold mu
+func (r *botRegistry) load() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+
+	saved := make(map[string]string)
+	if err := utils.LoadJSON(utils.DataPath(botAppsFile), &saved); err != nil {
+		log.Printf("Error loading bot app IDs: %v", err)
+		return
+	}
+	for botID, appID := range saved {
+		if _, ok := r.appIDs[botID]; !ok {
+			r.appIDs[botID] = &appIDEntry{id: appID}
+		}
+	}
+}
+
+// save - write successfully looked up app IDs. Caller must hold mu
+func (r *botRegistry) save() {
+	saved := make(map[string]string)
+	for botID, e := range r.appIDs {
+		if e.done == nil && !e.failed {
+			saved[botID] = e.id
+		}
+	}
+	if err := utils.SaveJSON(utils.DataPath(botAppsFile), saved); err != nil {
+		log.Printf("Error saving bot app IDs: %v", err)
+	}
+}
+
+// bots.info is called while a message waits for moderation - don't hold it long
+var botInfoClient = &http.Client{Timeout: 10 * time.Second}
+
+// lookupBotAppID - call bots.info directly, slack.Bot does not expose app_id
+func lookupBotAppID(botID string) (string, error) {
+	data := url.Values{}
+	data.Set("token", os.Getenv("SLACKBOT_API_TOKEN"))
+	data.Set("bot", botID)
+
+	resp, err := botInfoClient.PostForm(slackAPIURL+"bots.info", data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		Bot   struct {
+			AppID string `json:"app_id"`
+		} `json:"bot"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	if !info.OK {
+		return "", fmt.Errorf("bots.info: %s", info.Error)
+	}
+	return info.Bot.AppID, nil
+}
+
+// RecentBots - bots which posted in the last 7 days
+func (b *BlockConfig) RecentBots() []SeenBot {
+	return bots.recent(time.Now().Add(-7 * 24 * time.Hour))
+}
+
+// BotEntries - all bot allow-list entries (configured and added at runtime)
+func (b *BlockConfig) BotEntries() []BotEntry {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	entries := append([]BotEntry{}, b.AllowedBots...)
+	return append(entries, b.state.AllowedBots...)
+}
+
+// IsAllowedBot - true if bot matches any allow-list entry by bot ID, app ID or username
+func (b *BlockConfig) IsAllowedBot(botID, appID, username string) bool {
+	for _, e := range b.BotEntries() {
+		switch e.Kind {
+		case BotKindID:
+			if botID != "" && e.Value == botID {
+				return true
+			}
+		case BotKindApp:
+			if appID != "" && e.Value == appID {
+				return true
+			}
+		case BotKindName:
+			if username != "" && strings.EqualFold(e.Value, username) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasBotKind - true if any allow-list entry is of given kind
+func (b *BlockConfig) hasBotKind(kind string) bool {
+	for _, e := range b.BotEntries() {
+		if e.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowBot - add allow-list entry at runtime (kept between restarts)
+func (b *BlockConfig) AllowBot(e BotEntry) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	for _, existing := range b.state.AllowedBots {
+		if existing == e {
+			return
+		}
+	}
+	b.state.AllowedBots = append(b.state.AllowedBots, e)
+	b.saveState()
+}
+
+// RemoveBot - remove runtime allow-list entry. Entries from environment can't be removed
+func (b *BlockConfig) RemoveBot(e BotEntry) bool {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	for i, existing := range b.state.AllowedBots {
+		if existing == e {
+			b.state.AllowedBots = append(b.state.AllowedBots[:i], b.state.AllowedBots[i+1:]...)
+			b.saveState()
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedMessage - check message author: bots by allow-list, users by roles
+func (b *BlockConfig) isAllowedMessage(msg *slack.Msg) bool {
+	if msg.BotID == "" {
+		return b.IsAllowedWrite(msg.User)
+	}
+	if b.IsAllowedBot(msg.BotID, "", msg.Username) {
+		return true
+	}
+	// App ID needs bots.info for unknown bots - only look it up if any app is allow-listed
+	if b.hasBotKind(BotKindApp) && b.IsAllowedBot("", bots.appID(msg.BotID), "") {
+		return true
+	}
+	// Bot posting as a user (e.g. with user token) is allowed if the user is
+	return msg.User != "" && b.IsAllowedWrite(msg.User)
+}
@@ -0,0 +1,70 @@
+package rtm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// botsInfoServer - bots.info answering app ID A0000APP1 for every bot, returns number of calls
+func botsInfoServer(t *testing.T) *int32 {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, `{"ok":true,"bot":{"id":"%s","app_id":"A0000APP1"}}`, r.FormValue("bot"))
+	}))
+	t.Cleanup(srv.Close)
+
+	oldURL, oldBots := slackAPIURL, bots
+	slackAPIURL, bots = srv.URL+"/", newBotRegistry()
+	t.Cleanup(func() { slackAPIURL, bots = oldURL, oldBots })
+	t.Setenv("SLACKBOT_DATA_DIR", t.TempDir())
+	return &calls
+}
+
+func TestFirstMessageOfAllowedApp(t *testing.T) {
+	calls := botsInfoServer(t)
+	b := testConfig()
+	b.AllowedBots = []BotEntry{{Kind: BotKindApp, Value: "A0000APP1"}}
+
+	msg := &slack.Msg{BotID: "B0000BOT1", Username: "deploy"}
+	bots.record(msg)
+	if !b.isAllowedMessage(msg) {
+		t.Fatal("first message of allow-listed app is not allowed")
+	}
+	if !b.isAllowedMessage(msg) {
+		t.Fatal("second message of allow-listed app is not allowed")
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("bots.info called %d times, want 1", n)
+	}
+
+	// Restart - app ID comes from the saved cache
+	bots = newBotRegistry()
+	if !b.isAllowedMessage(msg) {
+		t.Fatal("allow-listed app not allowed after restart")
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("bots.info called %d times after restart, want 1", n)
+	}
+}
+
+func TestBotWithoutAppEntriesSkipsLookup(t *testing.T) {
+	calls := botsInfoServer(t)
+	b := testConfig()
+	b.AllowedBots = []BotEntry{{Kind: BotKindName, Value: "deploy"}}
+
+	if !b.isAllowedMessage(&slack.Msg{BotID: "B0000BOT1", Username: "deploy"}) {
+		t.Error("bot allowed by name is not allowed")
+	}
+	if b.isAllowedMessage(&slack.Msg{BotID: "B0000BOT2", Username: "other"}) {
+		t.Error("bot outside allow-list is allowed")
+	}
+	if n := atomic.LoadInt32(calls); n != 0 {
+		t.Errorf("bots.info called %d times without app entries", n)
+	}
+}
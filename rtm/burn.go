@@ -0,0 +1,181 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	burnStateFile            = "burn_state.json"
+	defaultBurnReactionDelay = time.Minute
+	defaultBurnReactionEmoji = "fire"
+	burnDeleteRetryInterval  = time.Minute
+	burnMaxDeleteRetries     = 5
+	burnMinDuration          = 5 * time.Second
+	burnMaxDuration          = 7 * 24 * time.Hour
+)
+
+var (
+	Burner = &BurnScheduler{}
+)
+
+// BurnDeletion - message scheduled for deletion
+type BurnDeletion struct {
+	Channel   string    `json:"channel"`
+	Timestamp string    `json:"ts"`
+	At        time.Time `json:"at"`
+	Retries   int       `json:"retries,omitempty"`
+}
+
+// BurnScheduler - deletes messages at given time. Scheduled deletions are kept between restarts
+type BurnScheduler struct {
+	Emoji         string
+	ReactionDelay time.Duration
+	api           *slack.Client
+
+	mu      sync.Mutex
+	pending []BurnDeletion
+}
+
+// Start - read config, load deletions scheduled before restart and arm them
+func (s *BurnScheduler) Start(api *slack.Client) {
+	s.api = api
+
+	s.Emoji = os.Getenv("SLACKBOT_BURN_EMOJI")
+	if s.Emoji == "" {
+		s.Emoji = defaultBurnReactionEmoji
+	}
+	s.ReactionDelay = defaultBurnReactionDelay
+	if v := os.Getenv("SLACKBOT_BURN_REACTION_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid SLACKBOT_BURN_REACTION_DELAY '%s', using %s", v, defaultBurnReactionDelay)
+		} else {
+			s.ReactionDelay = d
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := utils.LoadJSON(utils.DataPath(burnStateFile), &s.pending); err != nil {
+		log.Printf("Error loading burn state: %v", err)
+	}
+	for _, d := range s.pending {
+		s.arm(d)
+	}
+	log.Printf("Burn: %d deletion(s) pending, reaction :%s: deletes after %s\n", len(s.pending), s.Emoji, s.ReactionDelay)
+}
+
+// ParseBurnDuration - parse and check lifetime of a burn message
+func ParseBurnDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("can't understand duration '%s' - use e.g. 30s, 10m or 2h", s)
+	}
+	if d < burnMinDuration || d > burnMaxDuration {
+		return 0, fmt.Errorf("duration must be between %s and %s", burnMinDuration, burnMaxDuration)
+	}
+	return d, nil
+}
+
+// Post - post text to channel and delete it after given time
+func (s *BurnScheduler) Post(channelID, text string, after time.Duration) error {
+	if s.api == nil {
+		return fmt.Errorf("RTM module not running")
+	}
+
+	channel, ts, err := s.api.PostMessage(channelID,
+		slack.MsgOptionText(text+"\n"+mrkdwn.Italic(fmt.Sprintf("(disappears in %s)", after)), false),
+		slack.MsgOptionAsUser(false),
+		slack.MsgOptionUsername("Burn Bot"),
+		slack.MsgOptionIconEmoji(":fire:"))
+	if err != nil {
+		return err
+	}
+	s.Schedule(channel, ts, time.Now().Add(after))
+	return nil
+}
+
+// Schedule - delete message at given time
+func (s *BurnScheduler) Schedule(channelID, ts string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.pending {
+		if d.Channel == channelID && d.Timestamp == ts {
+			return
+		}
+	}
+	d := BurnDeletion{Channel: channelID, Timestamp: ts, At: at}
+	s.pending = append(s.pending, d)
+	s.save()
+	s.arm(d)
+}
+
+// onReaction - burn reaction by message author (or admin) schedules the message for deletion
+func (s *BurnScheduler) onReaction(ev *slack.ReactionAddedEvent) {
+	if s.api == nil || ev.Reaction != s.Emoji || ev.Item.Type != "message" {
+		return
+	}
+	if ev.User != ev.ItemUser && !Config.IsOwner(ev.User) && !Config.IsAdmin(ev.User) {
+		return
+	}
+	log.Printf("Burn: :%s: from %s on %s/%s - deleting in %s\n", ev.Reaction, ev.User, ev.Item.Channel, ev.Item.Timestamp, s.ReactionDelay)
+	s.Schedule(ev.Item.Channel, ev.Item.Timestamp, time.Now().Add(s.ReactionDelay))
+}
+
+// arm - start timer for deletion. Caller must hold mu
+func (s *BurnScheduler) arm(d BurnDeletion) {
+	time.AfterFunc(time.Until(d.At), func() { s.burn(d) })
+}
+
+func (s *BurnScheduler) burn(d BurnDeletion) {
+	_, _, err := s.api.DeleteMessage(d.Channel, d.Timestamp)
+	if err != nil && err.Error() != "message_not_found" && d.Retries < burnMaxDeleteRetries {
+		log.Printf("Burn: error deleting %s/%s, will retry: %v\n", d.Channel, d.Timestamp, err)
+		s.mu.Lock()
+		s.remove(d)
+		d.Retries++
+		d.At = time.Now().Add(burnDeleteRetryInterval)
+		s.pending = append(s.pending, d)
+		s.save()
+		s.arm(d)
+		s.mu.Unlock()
+		return
+	}
+
+	if err != nil {
+		log.Printf("Burn: giving up deleting %s/%s: %v\n", d.Channel, d.Timestamp, err)
+	} else {
+		log.Printf("Burn: deleted %s/%s\n", d.Channel, d.Timestamp)
+	}
+	s.mu.Lock()
+	s.remove(d)
+	s.save()
+	s.mu.Unlock()
+}
+
+// remove - drop deletion from pending list. Caller must hold mu
+func (s *BurnScheduler) remove(d BurnDeletion) {
+	for i := range s.pending {
+		if s.pending[i].Channel == d.Channel && s.pending[i].Timestamp == d.Timestamp {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// save - persist pending deletions. Caller must hold mu
+func (s *BurnScheduler) save() {
+	if err := utils.SaveJSON(utils.DataPath(burnStateFile), s.pending); err != nil {
+		log.Printf("Error saving burn state: %v", err)
+	}
+}
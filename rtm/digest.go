@@ -0,0 +1,352 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	digestStateFile = "digest_state.json"
+	// oldest mentions are dropped above this limit - digest is a reminder, not an archive
+	maxDigestMentions = 100
+	digestSnippetLen  = 150
+)
+
+var (
+	Digest = &DigestService{}
+)
+
+// DigestMention - mention of a user collected while the user was away
+type DigestMention struct {
+	Channel   string    `json:"channel"`
+	Timestamp string    `json:"ts"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	At        time.Time `json:"at"`
+}
+
+// digestState - digest users and their collected mentions, kept between restarts
+type digestState struct {
+	// Users - IDs of users who turned digest on with /digest on
+	Users map[string]bool `json:"users"`
+	// Pending - mentions collected per user ID
+	Pending map[string][]DigestMention `json:"pending,omitempty"`
+}
+
+// DigestService - collects mentions of away users and sends them one DM when they are back
+type DigestService struct {
+	api *slack.Client
+
+	mu    sync.Mutex
+	state digestState
+	// dirty - mentions collected since last save, saved by flushLoop
+	dirty bool
+	// workspaceURL - e.g. https://team.slack.com/ (from auth.test), used to build message links
+	workspaceURL string
+}
+
+// Start - load digest users and mentions collected before restart and watch presence of the users
+func (d *DigestService) Start(api *slack.Client) {
+	d.api = api
+
+	d.mu.Lock()
+	if err := utils.LoadJSON(utils.DataPath(digestStateFile), &d.state); err != nil {
+		log.Printf("Error loading digest state: %v", err)
+	}
+	if d.state.Users == nil {
+		d.state.Users = make(map[string]bool)
+	}
+	if d.state.Pending == nil {
+		d.state.Pending = make(map[string][]DigestMention)
+	}
+	var users []string
+	for id := range d.state.Users {
+		users = append(users, id)
+	}
+	d.mu.Unlock()
+
+	Presence.Watch(users...)
+	log.Printf("Digest: %d user(s) with digest on\n", len(users))
+
+	go d.loadWorkspaceURL()
+	go d.flushLoop()
+}
+
+// loadWorkspaceURL - get workspace URL once, so links to mentions don't need chat.getPermalink calls
+func (d *DigestService) loadWorkspaceURL() {
+	var auth *slack.AuthTestResponse
+	err := utils.RetryRateLimited("auth.test", func() (err error) {
+		auth, err = d.api.AuthTest()
+		return err
+	})
+	if err != nil {
+		log.Printf("Digest: error getting workspace URL, links need chat.getPermalink: %v", err)
+		return
+	}
+	d.mu.Lock()
+	d.workspaceURL = strings.TrimSuffix(auth.URL, "/") + "/"
+	d.mu.Unlock()
+}
+
+// Flush - save mentions collected since last save
+func (d *DigestService) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dirty {
+		d.save()
+	}
+}
+
+// flushLoop - save collected mentions every stateFlushInterval, not on every message
+func (d *DigestService) flushLoop() {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.Flush()
+	}
+}
+
+// Enable - turn digest on or off for user. Turning off drops collected mentions
+func (d *DigestService) Enable(userID string, on bool) error {
+	if d.api == nil {
+		return fmt.Errorf("RTM module not running")
+	}
+
+	d.mu.Lock()
+	if on {
+		d.state.Users[userID] = true
+	} else {
+		delete(d.state.Users, userID)
+		delete(d.state.Pending, userID)
+	}
+	d.save()
+	d.mu.Unlock()
+
+	if on {
+		Presence.Watch(userID)
+	}
+	return nil
+}
+
+// Enabled - true if user turned digest on
+func (d *DigestService) Enabled(userID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state.Users[userID]
+}
+
+// collect - remember mentions of away digest users in message (public and private channels, not DMs)
+func (d *DigestService) collect(msg *slack.Msg) {
+	if len(msg.SubType) != 0 || msg.Hidden || msg.User == "" {
+		return
+	}
+	kind := Config.groups.KindOfID(msg.Channel)
+	if kind == utils.KindIM {
+		return
+	}
+
+	// mentioned - user ID: true if user is mentioned only through a user group
+	mentioned := make(map[string]bool)
+	for _, t := range mrkdwn.Parse(msg.Text) {
+		switch t.Kind {
+		case mrkdwn.TokenUser:
+			mentioned[t.ID] = false
+		case mrkdwn.TokenUserGroup:
+			members, _ := Config.usergroups.Members(t.ID)
+			for _, id := range members {
+				if _, ok := mentioned[id]; !ok {
+					mentioned[id] = true
+				}
+			}
+		}
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+
+	// Public channel messages can be read by anyone. Private channel messages only by members, and user group
+	// mentions notify only members - others must not get them in digest
+	public := kind == utils.KindChannel
+	needMembers := !public
+	for _, grouped := range mentioned {
+		needMembers = needMembers || grouped
+	}
+	if !needMembers {
+		d.queue(msg, mentioned)
+		return
+	}
+	if members, ok := Config.groups.CachedMembers(msg.Channel); ok {
+		d.queue(msg, onlyMembers(mentioned, members, public))
+		return
+	}
+	if d.api == nil {
+		return
+	}
+	// Members are fetched once per channel, then kept current by member events - not on RTM loop
+	m := *msg
+	go func() {
+		members, err := Config.groups.GetMembers(d.api, m.Channel)
+		if err != nil {
+			log.Printf("Digest: error getting members of %s, mentions skipped: %v", m.Channel, err)
+			return
+		}
+		d.queue(&m, onlyMembers(mentioned, members, public))
+	}()
+}
+
+// onlyMembers - mentioned users who can read the message: channel members. In public channels users
+// mentioned directly are kept too
+func onlyMembers(mentioned map[string]bool, members []string, public bool) map[string]bool {
+	isMember := make(map[string]bool, len(members))
+	for _, id := range members {
+		isMember[id] = true
+	}
+	allowed := make(map[string]bool)
+	for id, grouped := range mentioned {
+		if isMember[id] || (public && !grouped) {
+			allowed[id] = grouped
+		}
+	}
+	return allowed
+}
+
+// queue - add message to digest of mentioned users who are away
+func (d *DigestService) queue(msg *slack.Msg, mentioned map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id := range mentioned {
+		if id == msg.User || !d.state.Users[id] || Presence.Of(id).Presence != presenceAway {
+			continue
+		}
+		pending := append(d.state.Pending[id], DigestMention{
+			Channel:   msg.Channel,
+			Timestamp: msg.Timestamp,
+			Author:    msg.User,
+			Text:      msg.Text,
+			At:        tsTime(msg.Timestamp),
+		})
+		if len(pending) > maxDigestMentions {
+			pending = pending[len(pending)-maxDigestMentions:]
+		}
+		d.state.Pending[id] = pending
+		// Collected on RTM loop - saved by flushLoop
+		d.dirty = true
+	}
+}
+
+// onActive - user is back - send collected mentions
+func (d *DigestService) onActive(userID string) {
+	d.mu.Lock()
+	waiting := len(d.state.Pending[userID]) > 0
+	d.mu.Unlock()
+
+	if waiting {
+		go func() {
+			if _, err := d.Send(userID); err != nil {
+				log.Printf("Digest: error sending to %s: %v", userID, err)
+			}
+		}()
+	}
+}
+
+// Send - send collected mentions to user as one DM and forget them. Returns number of mentions sent
+func (d *DigestService) Send(userID string) (int, error) {
+	if d.api == nil {
+		return 0, fmt.Errorf("RTM module not running")
+	}
+
+	d.mu.Lock()
+	mentions := d.state.Pending[userID]
+	delete(d.state.Pending, userID)
+	d.save()
+	d.mu.Unlock()
+
+	if len(mentions) == 0 {
+		return 0, nil
+	}
+
+	err := robots.SenderFor("").Send(robots.Message{
+		Channel:  userID,
+		Username: "Digest Bot",
+		Text:     d.format(mentions),
+	}).Wait()
+	if err != nil {
+		// Put mentions back, they will be sent next time
+		d.mu.Lock()
+		d.state.Pending[userID] = append(mentions, d.state.Pending[userID]...)
+		d.save()
+		d.mu.Unlock()
+		return 0, err
+	}
+	return len(mentions), nil
+}
+
+// format - digest text with mentions grouped by channel
+func (d *DigestService) format(mentions []DigestMention) string {
+	byChannel := make(map[string][]DigestMention)
+	var channels []string
+	for _, m := range mentions {
+		if _, ok := byChannel[m.Channel]; !ok {
+			channels = append(channels, m.Channel)
+		}
+		byChannel[m.Channel] = append(byChannel[m.Channel], m)
+	}
+	sort.Slice(channels, func(i, j int) bool { return Config.ChannelName(channels[i]) < Config.ChannelName(channels[j]) })
+
+	lines := []string{mrkdwn.Bold(fmt.Sprintf("While you were away you were mentioned %d time(s):", len(mentions)))}
+	for _, channel := range channels {
+		lines = append(lines, "", mrkdwn.Channel(channel))
+		for _, m := range byChannel[channel] {
+			text := mrkdwn.PlainText(mrkdwn.Parse(m.Text))
+			if r := []rune(text); len(r) > digestSnippetLen {
+				text = string(r[:digestSnippetLen]) + "…"
+			}
+			line := fmt.Sprintf("• %s %s: %s", mrkdwn.Date(m.At, mrkdwn.DateShortPretty+" "+mrkdwn.Time), mrkdwn.Bold("@"+Config.UserName(m.Author)),
+				mrkdwn.Escape(strings.Replace(text, "\n", " ", -1)))
+			if link, err := d.permalink(m); err == nil {
+				line += " " + mrkdwn.Link(link, "view")
+			} else {
+				log.Printf("Digest: no link to message %s in %s: %v", m.Timestamp, m.Channel, err)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// permalink - link to mentioned message built from workspace URL, like the ones chat.getPermalink returns.
+// chat.getPermalink is used only if workspace URL is not known
+func (d *DigestService) permalink(m DigestMention) (string, error) {
+	d.mu.Lock()
+	base := d.workspaceURL
+	d.mu.Unlock()
+	if base != "" {
+		return base + "archives/" + m.Channel + "/p" + strings.Replace(m.Timestamp, ".", "", 1), nil
+	}
+
+	var link string
+	err := utils.RetryRateLimited("chat.getPermalink", func() (err error) {
+		link, err = d.api.GetPermalink(&slack.PermalinkParameters{Channel: m.Channel, Ts: m.Timestamp})
+		return err
+	})
+	return link, err
+}
+
+// save - persist digest state. Caller must hold mu
+func (d *DigestService) save() {
+	if err := utils.SaveJSON(utils.DataPath(digestStateFile), &d.state); err != nil {
+		log.Printf("Error saving digest state: %v", err)
+		return
+	}
+	d.dirty = false
+}
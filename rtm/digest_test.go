@@ -0,0 +1,89 @@
+package rtm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+func TestDigestPermalink(t *testing.T) {
+	d := &DigestService{workspaceURL: "https://team.slack.com/"}
+	got, err := d.permalink(DigestMention{Channel: "C0123ABCD", Timestamp: "1392734382.000200"})
+	if err != nil {
+		t.Fatalf("permalink: %v", err)
+	}
+	if want := "https://team.slack.com/archives/C0123ABCD/p1392734382000200"; got != want {
+		t.Errorf("permalink = %s, want %s", got, want)
+	}
+}
+
+func TestDigestCollectOnlyChannelMembers(t *testing.T) {
+	// Members of every channel: U0000MEMB and the author. S0000TEAM: U0000MEMB and U0000OUTS
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"members":["U0000MEMB","U0000AUTH"],"response_metadata":{"next_cursor":""}}`)
+	}))
+	defer srv.Close()
+	api := slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+
+	oldConfig, oldPresence := Config, Presence
+	Config, Presence = &BlockConfig{}, &PresenceTracker{}
+	defer func() { Config, Presence = oldConfig, oldPresence }()
+
+	Config.groups.SetChannel("C0000PUBL", "general", utils.KindChannel)
+	Config.groups.SetChannel("G0000PRIV", "secret", utils.KindPrivate)
+	Config.groups.SetChannel("G0000LAZY", "secret2", utils.KindPrivate)
+	Config.usergroups.SetUserGroup(slack.UserGroup{ID: "S0000TEAM", Handle: "team", Users: []string{"U0000MEMB", "U0000OUTS"}})
+	if _, err := Config.groups.GetMembers(api, "G0000PRIV"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Config.groups.GetMembers(api, "C0000PUBL"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"U0000MEMB", "U0000OUTS"} {
+		Presence.record(id, presenceAway, time.Now())
+	}
+
+	tests := []struct {
+		name    string
+		channel string
+		text    string
+		want    map[string]int
+	}{
+		{"public, direct mention of non-member", "C0000PUBL", "<@U0000OUTS> see this", map[string]int{"U0000OUTS": 1}},
+		{"public, group mention", "C0000PUBL", "<!subteam^S0000TEAM|@team> see this", map[string]int{"U0000MEMB": 1}},
+		{"private, direct mentions", "G0000PRIV", "<@U0000OUTS> <@U0000MEMB> secret", map[string]int{"U0000MEMB": 1}},
+		{"private, group mention", "G0000PRIV", "<!subteam^S0000TEAM|@team> secret", map[string]int{"U0000MEMB": 1}},
+		{"private, members not cached yet", "G0000LAZY", "<!subteam^S0000TEAM|@team> <@U0000OUTS>", map[string]int{"U0000MEMB": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DigestService{api: api}
+			d.state.Users = map[string]bool{"U0000MEMB": true, "U0000OUTS": true}
+			d.state.Pending = make(map[string][]DigestMention)
+
+			d.collect(&slack.Msg{Channel: tt.channel, User: "U0000AUTH", Timestamp: "1500000000.000100", Text: tt.text})
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				d.mu.Lock()
+				got := make(map[string]int)
+				for id, m := range d.state.Pending {
+					got[id] = len(m)
+				}
+				d.mu.Unlock()
+				if reflect.DeepEqual(got, tt.want) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("pending mentions = %v, want %v", got, tt.want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
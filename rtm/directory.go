@@ -0,0 +1,240 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	defaultDirectoryRefresh = time.Hour
+	directoryCacheFile      = "directory_cache.json"
+)
+
+func init() {
+	// nlopes/slack doesn't map this event - without it group membership changes come as unmarshalling errors
+	slack.EventMapping["subteam_members_changed"] = slack.SubteamMembersChangedEvent{}
+}
+
+var (
+	// refreshRequests - full directory resync requested from outside of RTM loop (e.g. /block refresh)
+	refreshRequests = make(chan struct{}, 1)
+	// refreshDone - background resync finished, configured names can be resolved again
+	refreshDone = make(chan struct{}, 1)
+	// refreshing - 1 while background resync runs, so resyncs don't overlap
+	refreshing int32
+)
+
+// directoryRefreshInterval - how often users and channels are fully resynced (SLACKBOT_DIRECTORY_REFRESH)
+func directoryRefreshInterval() time.Duration {
+	v := os.Getenv("SLACKBOT_DIRECTORY_REFRESH")
+	if v == "" {
+		return defaultDirectoryRefresh
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid SLACKBOT_DIRECTORY_REFRESH '%s', using %s", v, defaultDirectoryRefresh)
+		return defaultDirectoryRefresh
+	}
+	return d
+}
+
+// RequestRefresh - ask RTM loop for full users and channels resync
+func (b *BlockConfig) RequestRefresh() {
+	select {
+	case refreshRequests <- struct{}{}:
+	default:
+		// refresh already pending
+	}
+}
+
+// refresh - start full resync of users, channels and user groups in background. Resync can take minutes
+// when rate limited, so it doesn't block RTM events. New directory is published when all lists are fetched,
+// then RTM loop gets refreshDone and calls refreshed
+func (b *BlockConfig) refresh() {
+	if b.api == nil {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&refreshing, 0, 1) {
+		log.Printf("Directory refresh already running")
+		return
+	}
+	go func() {
+		b.convertIDsToNames(b.api)
+		atomic.StoreInt32(&refreshing, 0)
+		select {
+		case refreshDone <- struct{}{}:
+		default:
+			// previous one not handled yet
+		}
+	}()
+}
+
+// refreshed - resolve configured names again after resync. Runs on RTM loop
+func (b *BlockConfig) refreshed() {
+	for _, err := range b.resolveIDs() {
+		log.Printf("WARNING! Block config: %v - entry ignored", err)
+	}
+	// New users are watched by default
+	go Presence.subscribe()
+	r := b.roles()
+	log.Printf("Directory refreshed. Channel: %v, Owner: %v, Admins: %v, Allowed: %v\n", r.Channel, r.Owner, r.Admins, r.AllowedUsers)
+}
+
+// loadDirectory - load users and channels saved on disk. Returns false if there is no usable cache
+func (b *BlockConfig) loadDirectory() bool {
+	saved, err := utils.LoadDirectory(utils.DataPath(directoryCacheFile), &b.users, &b.groups, &b.usergroups)
+	if err != nil {
+		log.Printf("Directory cache not loaded: %v", err)
+		return false
+	}
+	log.Printf("Directory loaded from cache saved %s (%s ago)", saved.Format("2006-01-02 15:04:05"), time.Since(saved).Round(time.Second))
+	return true
+}
+
+// saveDirectory - save users and channels to disk for next start
+func (b *BlockConfig) saveDirectory() {
+	if err := utils.SaveDirectory(utils.DataPath(directoryCacheFile), &b.users, &b.groups, &b.usergroups); err != nil {
+		log.Printf("Error saving directory cache: %v", err)
+	}
+}
+
+// updateDirectory - apply user, channel or user group change from RTM event to cached directory
+func (b *BlockConfig) updateDirectory(data interface{}) {
+	switch ev := data.(type) {
+	case *slack.TeamJoinEvent:
+		b.users.SetUser(ev.User)
+	case *slack.UserChangeEvent:
+		b.users.SetUser(ev.User)
+	case *slack.ChannelCreatedEvent:
+		b.groups.SetChannel(ev.Channel.ID, ev.Channel.Name, utils.KindChannel)
+	case *slack.ChannelJoinedEvent:
+		b.groups.SetChannel(ev.Channel.ID, ev.Channel.Name, utils.KindOf(&ev.Channel))
+	case *slack.ChannelRenameEvent:
+		b.groups.SetChannel(ev.Channel.ID, ev.Channel.Name, utils.KindChannel)
+	case *slack.ChannelDeletedEvent:
+		b.groups.RemoveChannel(ev.Channel)
+	case *slack.GroupCreatedEvent:
+		b.groups.SetChannel(ev.Channel.ID, ev.Channel.Name, utils.KindPrivate)
+	case *slack.GroupJoinedEvent:
+		// MPIMs are reported as groups too
+		b.groups.SetChannel(ev.Channel.ID, ev.Channel.Name, utils.KindOf(&ev.Channel))
+	case *slack.GroupRenameEvent:
+		b.groups.SetChannel(ev.Group.ID, ev.Group.Name, utils.KindPrivate)
+	case *slack.IMCreatedEvent:
+		b.groups.SetIM(ev.Channel.ID, ev.User)
+	case *slack.SubteamCreatedEvent:
+		b.usergroups.SetUserGroup(ev.Subteam)
+	case *slack.SubteamUpdatedEvent:
+		b.usergroups.SetUserGroup(ev.Subteam)
+	case *slack.SubteamMembersChangedEvent:
+		b.usergroups.ChangeMembers(ev.SubteamID, ev.AddedUsers, ev.RemovedUsers)
+	case *slack.MemberJoinedChannelEvent:
+		b.groups.AddMember(ev.Channel, ev.User)
+		// names don't change
+		return
+	case *slack.MemberLeftChannelEvent:
+		b.groups.RemoveMember(ev.Channel, ev.User)
+		return
+	default:
+		return
+	}
+	b.resolveIDs()
+}
+
+// resolveIDs - translate configured names to IDs. Entries already resolved keep their ID
+// and take the current name, so renamed users and channels keep working.
+// Entries which can't be resolved get empty ID and are returned as errors.
+// Roles are resolved on a copy and published under rolesMu - readers never see half-resolved roles
+func (b *BlockConfig) resolveIDs() []error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	r := b.roles()
+	r.Admins = append([]NameID{}, r.Admins...)
+	r.AllowedUsers = append([]NameID{}, r.AllowedUsers...)
+
+	add(b.resolve(&r.Channel, utils.EntityChannel))
+	// Roles can be given to users or user groups
+	add(b.resolve(&r.Owner, utils.EntityUser, utils.EntityUserGroup))
+	for i := range r.Admins {
+		add(b.resolve(&r.Admins[i], utils.EntityUser, utils.EntityUserGroup))
+	}
+	for i := range r.AllowedUsers {
+		add(b.resolve(&r.AllowedUsers[i], utils.EntityUser, utils.EntityUserGroup))
+	}
+
+	b.rolesMu.Lock()
+	b.Channel, b.Owner, b.Admins, b.AllowedUsers = r.Channel, r.Owner, r.Admins, r.AllowedUsers
+	b.rolesMu.Unlock()
+	return errs
+}
+
+// blockRoles - blocked channel and roles at one moment
+type blockRoles struct {
+	Channel      NameID
+	Owner        NameID
+	Admins       []NameID
+	AllowedUsers []NameID
+}
+
+// roles - current blocked channel and roles. Slices are replaced by resolveIDs, never changed in place,
+// so they can be read after the lock is released
+func (b *BlockConfig) roles() blockRoles {
+	b.rolesMu.RLock()
+	defer b.rolesMu.RUnlock()
+	return blockRoles{Channel: b.Channel, Owner: b.Owner, Admins: b.Admins, AllowedUsers: b.AllowedUsers}
+}
+
+// BlockedChannel - blocked channel with resolved ID (empty ID if not found)
+func (b *BlockConfig) BlockedChannel() NameID {
+	return b.roles().Channel
+}
+
+// resolve - resolve entry to entity of one of given kinds
+func (b *BlockConfig) resolve(n *NameID, kinds ...utils.EntityKind) error {
+	if n.Name == "" && n.ID == "" {
+		// not configured
+		return nil
+	}
+
+	ref := n.Name
+	if n.ID != "" {
+		ref = n.ID
+	}
+	e, err := b.Directory().Lookup(ref)
+	if err == nil && !hasKind(e.Kind, kinds) {
+		err = fmt.Errorf("%s is a %s, not a %s", ref, e.Kind, kinds[0])
+	}
+	if err != nil && n.ID != "" && n.Name != "" {
+		// ID no longer known (e.g. deleted) - try configured name again
+		n.ID = ""
+		return b.resolve(n, kinds...)
+	}
+	if err != nil {
+		n.ID = ""
+		return err
+	}
+
+	n.ID = e.ID
+	n.Name = e.String()
+	return nil
+}
+
+func hasKind(kind utils.EntityKind, kinds []utils.EntityKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
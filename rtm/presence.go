@@ -0,0 +1,282 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	presenceActive = "active"
+	presenceAway   = "away"
+
+	// Slack accepts at most 500 IDs in one presence subscription
+	maxPresenceSubscriptions = 500
+	maxPresenceHistory       = 20
+)
+
+var (
+	Presence = &PresenceTracker{started: time.Now()}
+)
+
+// PresenceTransition - user presence changed at given time
+type PresenceTransition struct {
+	Presence string
+	At       time.Time
+}
+
+// UserPresence - current presence of user with recent transitions, oldest first
+type UserPresence struct {
+	UserID   string
+	Presence string
+	// Since - time of last transition (zero if presence was not reported since start)
+	Since time.Time
+	// LastSeen - last time user was active (zero if not seen active since start)
+	LastSeen time.Time
+	History  []PresenceTransition
+}
+
+// PresenceTracker - presence of users from RTM presence_change events
+type PresenceTracker struct {
+	started time.Time
+
+	mu    sync.Mutex
+	users map[string]*UserPresence
+	// watched - users subscribed in addition to the default ones (e.g. digest users)
+	watched map[string]bool
+	rtm     *slack.RTM
+
+	// subscribeMu - one subscription at a time, so the last one sent is built from current state
+	subscribeMu sync.Mutex
+}
+
+// record - presence change of user at given time
+func (t *PresenceTracker) record(userID, presence string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.users == nil {
+		t.users = make(map[string]*UserPresence)
+	}
+	p, ok := t.users[userID]
+	if !ok {
+		p = &UserPresence{UserID: userID}
+		t.users[userID] = p
+	}
+	if p.Presence == presence {
+		return
+	}
+
+	// Active until now - it's the last time user was seen
+	if p.Presence == presenceActive || presence == presenceActive {
+		p.LastSeen = at
+	}
+	p.Presence = presence
+	p.Since = at
+	p.History = append(p.History, PresenceTransition{Presence: presence, At: at})
+	if len(p.History) > maxPresenceHistory {
+		p.History = p.History[len(p.History)-maxPresenceHistory:]
+	}
+}
+
+// onPresenceChange - presence_change event. Slack sends one event for many users after subscription
+func (t *PresenceTracker) onPresenceChange(ev *slack.PresenceChangeEvent) {
+	now := time.Now()
+	users := ev.Users
+	if ev.User != "" {
+		users = append(users, ev.User)
+	}
+	for _, u := range users {
+		Config.users.SetPresenceByID(u, ev.Presence)
+		t.record(u, ev.Presence, now)
+		if ev.Presence == presenceActive {
+			Digest.onActive(u)
+		}
+	}
+}
+
+// Of - presence of user. Users without presence events get presence from users list
+func (t *PresenceTracker) Of(userID string) UserPresence {
+	t.mu.Lock()
+	p, ok := t.users[userID]
+	var result UserPresence
+	if ok {
+		result = *p
+		result.History = append([]PresenceTransition{}, p.History...)
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		result.UserID = userID
+		result.Presence, _ = Config.users.GetPresenceByID(userID)
+	}
+	if result.Presence == presenceActive {
+		result.LastSeen = time.Now()
+	}
+	return result
+}
+
+// Started - time tracking started. Users not seen active are away at least since then
+func (t *PresenceTracker) Started() time.Time {
+	return t.started
+}
+
+// Watch - subscribe to presence of users in addition to the default ones
+func (t *PresenceTracker) Watch(userIDs ...string) {
+	t.mu.Lock()
+	if t.watched == nil {
+		t.watched = make(map[string]bool)
+	}
+	added := false
+	for _, id := range userIDs {
+		if !t.watched[id] {
+			t.watched[id] = true
+			added = true
+		}
+	}
+	t.mu.Unlock()
+
+	if added {
+		go t.subscribe()
+	}
+}
+
+// connected - (re)subscribe presence after RTM connect. Subscription doesn't survive reconnect
+func (t *PresenceTracker) connected(rtm *slack.RTM) {
+	t.mu.Lock()
+	t.rtm = rtm
+	t.mu.Unlock()
+	go t.subscribe()
+}
+
+// subscribe - send presence subscription for watched users and users from SLACKBOT_PRESENCE_USERS
+// (all active people if not set). Every subscription replaces the previous one.
+// Channel members can be fetched from Slack - run it in goroutine, not on RTM loop
+func (t *PresenceTracker) subscribe() {
+	t.subscribeMu.Lock()
+	defer t.subscribeMu.Unlock()
+
+	ids := t.subscriptionIDs()
+
+	t.mu.Lock()
+	rtm := t.rtm
+	t.mu.Unlock()
+	if rtm == nil {
+		return
+	}
+
+	if len(ids) > maxPresenceSubscriptions {
+		log.Printf("Presence: %d users to watch, subscribing first %d", len(ids), maxPresenceSubscriptions)
+		ids = ids[:maxPresenceSubscriptions]
+	}
+	rtm.SendMessage(rtm.NewSubscribeUserPresence(ids))
+	log.Printf("Presence: subscribed %d user(s)", len(ids))
+}
+
+// subscriptionIDs - users to subscribe, explicitly watched first so they fit the limit
+func (t *PresenceTracker) subscriptionIDs() []string {
+	var ids []string
+	set := make(map[string]bool)
+
+	t.mu.Lock()
+	for id := range t.watched {
+		ids = append(ids, id)
+		set[id] = true
+	}
+	t.mu.Unlock()
+	sort.Strings(ids)
+
+	others := make(map[string]bool)
+	if refs := os.Getenv("SLACKBOT_PRESENCE_USERS"); refs != "" {
+		for _, ref := range strings.Fields(refs) {
+			members, err := Config.Members(ref)
+			if err != nil {
+				log.Printf("Presence: skipping %s: %v", ref, err)
+				continue
+			}
+			for _, id := range members {
+				others[id] = true
+			}
+		}
+	} else {
+		for _, u := range Config.users.Users() {
+			if !u.Deleted && !u.IsBot && u.ID != "USLACKBOT" {
+				others[u.ID] = true
+			}
+		}
+	}
+
+	var rest []string
+	for id := range others {
+		if !set[id] {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	return append(ids, rest...)
+}
+
+// Members - user IDs of a user (@bob), user group members (@team) or channel members (#general).
+// Channel members come from directory cache, only the first lookup of a channel calls Slack
+func (b *BlockConfig) Members(ref string) ([]string, error) {
+	e, err := b.Directory().Lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Kind {
+	case utils.EntityUser:
+		return []string{e.ID}, nil
+	case utils.EntityUserGroup:
+		return b.usergroups.Members(e.ID)
+	case utils.EntityChannel:
+		if members, ok := b.groups.CachedMembers(e.ID); ok {
+			return members, nil
+		}
+		if b.api == nil {
+			return nil, fmt.Errorf("RTM module not running")
+		}
+		return b.groups.GetMembers(b.api, e.ID)
+	}
+	return nil, fmt.Errorf("can't list members of %s", ref)
+}
+
+// UserName - translate user ID to name (ID itself if unknown, so it is still usable in messages)
+func (b *BlockConfig) UserName(id string) string {
+	name, err := b.users.IDToName(id)
+	if err != nil {
+		return id
+	}
+	return name
+}
+
+// WhoIsIn - presence of people (without bots) in user group or channel, active first, then by name
+func (t *PresenceTracker) WhoIsIn(ref string) ([]UserPresence, error) {
+	members, err := Config.Members(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []UserPresence
+	for _, id := range members {
+		if u, err := Config.users.FindUser(id); err == nil && (u.IsBot || u.Deleted || u.ID == "USLACKBOT") {
+			continue
+		}
+		list = append(list, t.Of(id))
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		ai, aj := list[i].Presence == presenceActive, list[j].Presence == presenceActive
+		if ai != aj {
+			return ai
+		}
+		return Config.UserName(list[i].UserID) < Config.UserName(list[j].UserID)
+	})
+	return list, nil
+}
@@ -0,0 +1,284 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	defaultRetentionInterval = time.Hour
+	// chat.delete allows ~50 calls per minute
+	retentionDeletePause = 1200 * time.Millisecond
+)
+
+var (
+	Retention = &RetentionConfig{}
+)
+
+// RetentionPolicy - how long messages are kept in a channel
+type RetentionPolicy struct {
+	Channel NameID
+	MaxAge  time.Duration
+}
+
+// RetentionConfig - auto-delete of old messages per channel with optional exemptions
+type RetentionConfig struct {
+	Policies      []RetentionPolicy
+	Interval      time.Duration
+	KeepPinned    bool
+	KeepThreads   bool
+	KeepReactions []string
+	api           *slack.Client
+
+	// only one pass (scheduled or report) at a time - they share rate limit
+	runMu sync.Mutex
+}
+
+// RetentionResult - outcome of a retention pass in one channel
+type RetentionResult struct {
+	Channel string
+	Scanned int
+	Deleted int
+	Kept    int
+	Failed  int
+	DryRun  bool
+}
+
+func (r RetentionResult) String() string {
+	if r.DryRun {
+		return fmt.Sprintf("%s: scanned %d, would delete %d, kept (exempt) %d", r.Channel, r.Scanned, r.Deleted, r.Kept)
+	}
+	return fmt.Sprintf("%s: scanned %d, deleted %d, kept (exempt) %d, failed %d", r.Channel, r.Scanned, r.Deleted, r.Kept, r.Failed)
+}
+
+// ReadRetentionConfig - read SLACKBOT_RETENTION ("#tmp=1 #alerts-noise=7" - channel=days) and exemptions
+func (r *RetentionConfig) ReadRetentionConfig(api *slack.Client) {
+	r.api = api
+
+	r.Policies = parseRetentionPolicies(os.Getenv("SLACKBOT_RETENTION"), Config.ChannelID)
+
+	r.Interval = defaultRetentionInterval
+	if v := os.Getenv("SLACKBOT_RETENTION_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid SLACKBOT_RETENTION_INTERVAL '%s', using %s", v, defaultRetentionInterval)
+		} else {
+			r.Interval = d
+		}
+	}
+
+	r.KeepPinned = os.Getenv("SLACKBOT_RETENTION_KEEP_PINNED") != "false"
+	r.KeepThreads = os.Getenv("SLACKBOT_RETENTION_KEEP_THREADS") == "true"
+	r.KeepReactions = strings.Fields(strings.Replace(os.Getenv("SLACKBOT_RETENTION_KEEP_REACTIONS"), ":", "", -1))
+
+	log.Printf("Retention policies: %v, every %s, keep pinned: %t, keep threads: %t, keep reactions: %v\n",
+		r.Policies, r.Interval, r.KeepPinned, r.KeepThreads, r.KeepReactions)
+}
+
+// parseRetentionPolicies - parse "#tmp=1 #alerts-noise=7" (channel=days). Invalid entries and unknown channels
+// are logged and skipped
+func parseRetentionPolicies(spec string, channelID func(string) string) []RetentionPolicy {
+	var policies []RetentionPolicy
+	for _, elem := range strings.Fields(spec) {
+		parts := strings.SplitN(elem, "=", 2)
+		if len(parts) != 2 {
+			log.Printf("Skipping retention policy '%s' - use #channel=days", elem)
+			continue
+		}
+		days, err := strconv.Atoi(parts[1])
+		if err != nil || days < 1 {
+			log.Printf("Skipping retention policy '%s' - days must be a positive number", elem)
+			continue
+		}
+		id := channelID(parts[0])
+		if id == "" {
+			log.Printf("Skipping retention policy '%s' - unknown channel", elem)
+			continue
+		}
+		policies = append(policies, RetentionPolicy{
+			Channel: NameID{Name: parts[0], ID: id},
+			MaxAge:  time.Duration(days) * 24 * time.Hour,
+		})
+	}
+	return policies
+}
+
+// Run - apply retention policies every Interval. Blocks - run in goroutine
+func (r *RetentionConfig) Run() {
+	if len(r.Policies) == 0 {
+		return
+	}
+	for {
+		for _, res := range r.Apply(false) {
+			log.Printf("Retention: %s", res)
+		}
+		time.Sleep(r.Interval)
+	}
+}
+
+// Apply - delete (or with dryRun only count) messages older than policy allows in all configured channels
+func (r *RetentionConfig) Apply(dryRun bool) []RetentionResult {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	var results []RetentionResult
+	for _, policy := range r.Policies {
+		res, err := r.applyPolicy(policy, dryRun)
+		if err != nil {
+			log.Printf("Retention of %s failed: %v", policy.Channel.Name, err)
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Channel < results[j].Channel })
+	return results
+}
+
+func (r *RetentionConfig) applyPolicy(policy RetentionPolicy, dryRun bool) (RetentionResult, error) {
+	res := RetentionResult{Channel: policy.Channel.Name, DryRun: dryRun}
+	if r.api == nil {
+		return res, fmt.Errorf("RTM module not running")
+	}
+
+	cutoff := toTimestamp(time.Now().Add(-policy.MaxAge))
+	params := slack.NewHistoryParameters()
+	params.Latest = cutoff
+	params.Count = historyPageSize
+
+	for {
+		history, err := r.historyPage(policy.Channel.ID, params)
+		if err != nil {
+			return res, err
+		}
+
+		for _, msg := range history.Messages {
+			// History has only top-level messages - old replies of a thread are removed before its parent
+			if !r.KeepThreads && msg.ReplyCount > 0 {
+				replies, err := r.threadReplies(policy.Channel.ID, msg.Timestamp, cutoff)
+				if err != nil {
+					log.Printf("Retention: error getting replies of %s/%s: %v", policy.Channel.Name, msg.Timestamp, err)
+				}
+				for i := range replies {
+					r.applyToMessage(policy.Channel, &replies[i].Msg, dryRun, &res)
+				}
+			}
+			r.applyToMessage(policy.Channel, &msg.Msg, dryRun, &res)
+		}
+
+		if !history.HasMore || len(history.Messages) == 0 {
+			break
+		}
+		params.Latest = history.Messages[len(history.Messages)-1].Timestamp
+	}
+	return res, nil
+}
+
+// applyToMessage - delete (or with dryRun only count) message unless it is exempt
+func (r *RetentionConfig) applyToMessage(channel NameID, msg *slack.Msg, dryRun bool, res *RetentionResult) {
+	res.Scanned++
+	if r.isExempt(msg) {
+		res.Kept++
+		return
+	}
+	if dryRun {
+		res.Deleted++
+		return
+	}
+	if err := r.deleteMessage(channel, msg); err != nil {
+		log.Printf("Retention: error deleting %s/%s: %v", channel.Name, msg.Timestamp, err)
+		res.Failed++
+		return
+	}
+	res.Deleted++
+}
+
+// isExempt - message kept regardless of age (pinned, in a thread or with a keep reaction)
+func (r *RetentionConfig) isExempt(msg *slack.Msg) bool {
+	if msg.Hidden {
+		return true
+	}
+	if r.KeepPinned && len(msg.PinnedTo) > 0 {
+		return true
+	}
+	if r.KeepThreads && (msg.ReplyCount > 0 || msg.ThreadTimestamp != "") {
+		return true
+	}
+	for _, reaction := range msg.Reactions {
+		for _, keep := range r.KeepReactions {
+			if reaction.Name == keep {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// historyPage - channel history, waiting out rate limits
+func (r *RetentionConfig) historyPage(channelID string, params slack.HistoryParameters) (*slack.History, error) {
+	for {
+		history, err := channelHistory(r.api, channelID, params)
+		if rateErr, ok := err.(*slack.RateLimitedError); ok {
+			log.Printf("Retention: rate limited, waiting %s", rateErr.RetryAfter)
+			time.Sleep(rateErr.RetryAfter)
+			continue
+		}
+		return history, err
+	}
+}
+
+// threadReplies - replies of thread posted before latest (without the parent), waiting out rate limits
+func (r *RetentionConfig) threadReplies(channelID, threadTS, latest string) ([]slack.Message, error) {
+	var replies []slack.Message
+	params := &slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: threadTS,
+		Latest:    latest,
+		Limit:     historyPageSize,
+	}
+	for {
+		msgs, hasMore, cursor, err := r.api.GetConversationReplies(params)
+		if rateErr, ok := err.(*slack.RateLimitedError); ok {
+			log.Printf("Retention: rate limited, waiting %s", rateErr.RetryAfter)
+			time.Sleep(rateErr.RetryAfter)
+			continue
+		}
+		if err != nil {
+			return replies, err
+		}
+		for _, msg := range msgs {
+			if msg.Timestamp != threadTS {
+				replies = append(replies, msg)
+			}
+		}
+		if !hasMore || cursor == "" {
+			return replies, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// deleteMessage - delete message, waiting out rate limits, and log it
+func (r *RetentionConfig) deleteMessage(channel NameID, msg *slack.Msg) error {
+	for {
+		_, _, err := r.api.DeleteMessage(channel.ID, msg.Timestamp)
+		if rateErr, ok := err.(*slack.RateLimitedError); ok {
+			log.Printf("Retention: rate limited, waiting %s", rateErr.RetryAfter)
+			time.Sleep(rateErr.RetryAfter)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Retention: deleted %s/%s (user: %s, bot: %s, posted: %s)", channel.Name, msg.Timestamp, msg.User, msg.BotID,
+			tsTime(msg.Timestamp).Format("2006-01-02 15:04:05"))
+		time.Sleep(retentionDeletePause)
+		return nil
+	}
+}
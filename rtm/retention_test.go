@@ -0,0 +1,104 @@
+package rtm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestIsExempt(t *testing.T) {
+	r := &RetentionConfig{KeepPinned: true, KeepReactions: []string{"pushpin", "keep"}}
+	threads := &RetentionConfig{KeepThreads: true}
+	tests := []struct {
+		name string
+		r    *RetentionConfig
+		msg  slack.Msg
+		want bool
+	}{
+		{"plain message", r, slack.Msg{Text: "old"}, false},
+		{"hidden", r, slack.Msg{Hidden: true}, true},
+		{"pinned", r, slack.Msg{PinnedTo: []string{"C0000TEMP"}}, true},
+		{"pinned without keep pinned", threads, slack.Msg{PinnedTo: []string{"C0000TEMP"}}, false},
+		{"keep reaction", r, slack.Msg{Reactions: []slack.ItemReaction{{Name: "thumbsup"}, {Name: "keep"}}}, true},
+		{"other reaction", r, slack.Msg{Reactions: []slack.ItemReaction{{Name: "thumbsup"}}}, false},
+		{"thread parent, threads kept", threads, slack.Msg{ReplyCount: 2}, true},
+		{"thread reply, threads kept", threads, slack.Msg{ThreadTimestamp: "1500000000.000100"}, true},
+		{"thread parent, threads not kept", r, slack.Msg{ReplyCount: 2}, false},
+		{"thread reply, threads not kept", r, slack.Msg{ThreadTimestamp: "1500000000.000100"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.isExempt(&tt.msg); got != tt.want {
+				t.Errorf("isExempt = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetentionPolicies(t *testing.T) {
+	channels := map[string]string{"#tmp": "C0000TEMP", "#alerts-noise": "C0000NOIS"}
+	channelID := func(ref string) string { return channels[ref] }
+	day := 24 * time.Hour
+	tests := []struct {
+		spec string
+		want []RetentionPolicy
+	}{
+		{"", nil},
+		{"#tmp=1 #alerts-noise=7", []RetentionPolicy{
+			{Channel: NameID{Name: "#tmp", ID: "C0000TEMP"}, MaxAge: day},
+			{Channel: NameID{Name: "#alerts-noise", ID: "C0000NOIS"}, MaxAge: 7 * day},
+		}},
+		{"#tmp #tmp=x #tmp=0 #tmp=-1", nil},
+		{"#unknown=3 #tmp=2", []RetentionPolicy{{Channel: NameID{Name: "#tmp", ID: "C0000TEMP"}, MaxAge: 2 * day}}},
+	}
+	for _, tt := range tests {
+		if got := parseRetentionPolicies(tt.spec, channelID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRetentionPolicies(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPolicyIncludesThreadReplies(t *testing.T) {
+	var repliesLatest string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/conversations.history":
+			fmt.Fprint(w, `{"ok":true,"has_more":false,"messages":[
+				{"type":"message","ts":"1400000002.000100","text":"plain"},
+				{"type":"message","ts":"1400000001.000100","text":"parent","thread_ts":"1400000001.000100","reply_count":2}]}`)
+		case "/conversations.replies":
+			repliesLatest = r.FormValue("latest")
+			fmt.Fprint(w, `{"ok":true,"has_more":false,"messages":[
+				{"type":"message","ts":"1400000001.000100","text":"parent","thread_ts":"1400000001.000100","reply_count":2},
+				{"type":"message","ts":"1400000003.000100","text":"reply","thread_ts":"1400000001.000100"},
+				{"type":"message","ts":"1400000004.000100","text":"kept reply","thread_ts":"1400000001.000100","reactions":[{"name":"keep","count":1}]}]}`)
+		default:
+			t.Errorf("unexpected call %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	r := &RetentionConfig{KeepReactions: []string{"keep"}, api: slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))}
+	res, err := r.applyPolicy(RetentionPolicy{Channel: NameID{Name: "#tmp", ID: "C0000TEMP"}, MaxAge: 24 * time.Hour}, true)
+	if err != nil {
+		t.Fatalf("applyPolicy: %v", err)
+	}
+	if res.Scanned != 4 || res.Deleted != 3 || res.Kept != 1 {
+		t.Errorf("result = %s, want scanned 4, would delete 3, kept 1", res)
+	}
+	if repliesLatest == "" {
+		t.Error("replies requested without age limit")
+	}
+
+	r.KeepThreads = true
+	repliesLatest = ""
+	res, _ = r.applyPolicy(RetentionPolicy{Channel: NameID{Name: "#tmp", ID: "C0000TEMP"}, MaxAge: 24 * time.Hour}, true)
+	if res.Scanned != 2 || res.Deleted != 1 || res.Kept != 1 || repliesLatest != "" {
+		t.Errorf("with threads kept: result = %s, replies fetched: %t", res, repliesLatest != "")
+	}
+}
@@ -0,0 +1,78 @@
+package rtm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+// testConfig - block config with directory of two users, one user group and one channel
+func testConfig() *BlockConfig {
+	b := &BlockConfig{}
+	b.users.SetUser(slack.User{ID: "U0000OWNR", Name: "owner"})
+	b.users.SetUser(slack.User{ID: "U0000ADMN", Name: "admin"})
+	b.usergroups.SetUserGroup(slack.UserGroup{ID: "S0000MODS", Handle: "mods", Users: []string{"U0000ADMN"}})
+	b.groups.SetChannel("C0000BLKD", "blocked", utils.KindChannel)
+
+	b.Channel = NameID{Name: "blocked"}
+	b.Owner = NameID{Name: "owner"}
+	b.Admins = []NameID{{Name: "admin"}, {Name: "@mods"}}
+	b.AllowedUsers = []NameID{{Name: "admin"}}
+	return b
+}
+
+func TestResolveIDs(t *testing.T) {
+	b := testConfig()
+	if errs := b.resolveIDs(); len(errs) > 0 {
+		t.Fatalf("resolveIDs: %v", errs)
+	}
+	if got := b.BlockedChannel().ID; got != "C0000BLKD" {
+		t.Errorf("blocked channel ID = %q, want C0000BLKD", got)
+	}
+	if !b.IsOwner("U0000OWNR") || b.IsOwner("U0000ADMN") {
+		t.Errorf("IsOwner: owner %t, admin %t", b.IsOwner("U0000OWNR"), b.IsOwner("U0000ADMN"))
+	}
+	if !b.IsAdmin("U0000ADMN") || b.IsAdmin("U0000OWNR") {
+		t.Errorf("IsAdmin: admin %t, owner %t", b.IsAdmin("U0000ADMN"), b.IsAdmin("U0000OWNR"))
+	}
+}
+
+// Run with -race: role reads during resync must not race with resolveIDs
+func TestRolesConcurrentResolve(t *testing.T) {
+	b := testConfig()
+	b.resolveIDs()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			// Renames come from directory events, resolveIDs picks up the new names
+			b.updateDirectory(&slack.UserChangeEvent{User: slack.User{ID: "U0000ADMN", Name: fmt.Sprintf("admin%d", i)}})
+			b.updateDirectory(&slack.ChannelRenameEvent{Channel: slack.ChannelRenameInfo{ID: "C0000BLKD", Name: fmt.Sprintf("blocked%d", i)}})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if !b.IsAdmin("U0000ADMN") {
+				t.Errorf("admin lost admin role during resolve")
+			}
+			b.IsOwner("U0000OWNR")
+			b.IsAllowedUser("U0000ADMN")
+			if b.BlockedChannel().ID != "C0000BLKD" {
+				t.Errorf("blocked channel lost ID during resolve")
+			}
+			_ = b.AdminNames()
+			_ = b.AllowedUsersNames()
+		}
+	}()
+	wg.Wait()
+
+	if got := b.AdminNames(); len(got) != 2 || got[0] != "@admin199" {
+		t.Errorf("AdminNames after renames = %v", got)
+	}
+}
@@ -4,35 +4,70 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"log"
 
 	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/mrkdwn"
+	"github.com/wojtekzw/slackbot/robots"
 	"github.com/wojtekzw/slackbot/utils"
 )
 
+// Deleted message notice destinations
+const (
+	noticeDM      = "dm"
+	noticeChannel = "channel"
+	noticeBoth    = "both"
+)
+
 var (
 	Config = &BlockConfig{}
+
+	slackbotRTMDebug = false
 )
 
 type NameID struct {
 	Name string
 	ID   string
 }
+
+// BlockConfig - blocked channel, roles and moderation state. Channel and roles are set by ReadBlockChannelConfig
+// and then changed only by resolveIDs under rolesMu - read them with BlockedChannel and role methods
 type BlockConfig struct {
 	Channel      NameID
 	Owner        NameID
 	Admins       []NameID
 	AllowedUsers []NameID
+	AllowedBots  []BotEntry
 	DeletedMsg   string
-	users        utils.GlobalUsers
-	groups       utils.GlobalChannels
+	// DeletedNotice - where author of deleted message is told: "dm" (default), "channel" (ephemeral) or "both"
+	DeletedNotice string
+	users         utils.GlobalUsers
+	groups        utils.GlobalChannels
+	usergroups    utils.GlobalUserGroups
+	api           *slack.Client
+
+	rolesMu sync.RWMutex
+
+	stateMu sync.Mutex
+	state   blockState
+	// stateDirty - state changed since last save (high-water mark)
+	stateDirty bool
+
+	// catchUpMu - one catch-up scan at a time (reconnects can come faster than a scan finishes)
+	catchUpMu sync.Mutex
 }
 
 // FIXME: Trim && ToLower all env strings
 // walidacja danych zewnętrznych - co robic w razie błedów
-func (b *BlockConfig) ReadBlockChannelConfig(api *slack.Client) {
+// Configured names which don't resolve are logged as warnings, with SLACKBOT_STRICT_CONFIG=true they are an error
+func (b *BlockConfig) ReadBlockChannelConfig(api *slack.Client) error {
+
+	b.api = api
 
+	b.rolesMu.Lock()
 	b.Channel.Name = os.Getenv("SLACKBOT_BLOCK_CHANNEL_NAME")
 
 	b.Owner.Name = os.Getenv("SLACKBOT_OWNER_NAME")
@@ -46,48 +81,101 @@ func (b *BlockConfig) ReadBlockChannelConfig(api *slack.Client) {
 	for _, elem := range strings.Fields(defUserName) {
 		b.AllowedUsers = append(b.AllowedUsers, NameID{Name: elem})
 	}
+	b.rolesMu.Unlock()
+
+	for _, elem := range strings.Fields(os.Getenv("SLACKBOT_ALLOWED_BOTS")) {
+		entry, err := ParseBotEntry(elem)
+		if err != nil {
+			log.Printf("Skipping allowed bot: %v", err)
+			continue
+		}
+		b.AllowedBots = append(b.AllowedBots, entry)
+	}
 
 	b.DeletedMsg = os.Getenv("SLACKBOT_DELETED_MSG")
 
-	log.Printf("Admins: %v len %v cap %v\n", b.Admins, len(b.Admins), cap(b.Admins))
-	log.Printf("Allowed: %v  len %v cap %v\n", b.AllowedUsers, len(b.AllowedUsers), cap(b.AllowedUsers))
+	b.DeletedNotice = strings.ToLower(os.Getenv("SLACKBOT_DELETED_NOTICE"))
+	switch b.DeletedNotice {
+	case noticeDM, noticeChannel, noticeBoth:
+	case "":
+		b.DeletedNotice = noticeDM
+	default:
+		log.Printf("Invalid SLACKBOT_DELETED_NOTICE '%s', using %s", b.DeletedNotice, noticeDM)
+		b.DeletedNotice = noticeDM
+	}
 
-	b.convertIDsToNames(api)
+	configured := b.roles()
+	log.Printf("Admins: %v len %v cap %v\n", configured.Admins, len(configured.Admins), cap(configured.Admins))
+	log.Printf("Allowed: %v  len %v cap %v\n", configured.AllowedUsers, len(configured.AllowedUsers), cap(configured.AllowedUsers))
+	log.Printf("Allowed bots: %v\n", b.AllowedBots)
 
-	log.Printf("Admins 2: %v len %v cap %v\n", b.Admins, len(b.Admins), cap(b.Admins))
-	log.Printf("Allowed 2: %v  len %v cap %v\n", b.AllowedUsers, len(b.AllowedUsers), cap(b.AllowedUsers))
+	if b.loadDirectory() {
+		// Resolve from last known directory now, revalidate with Slack in RTM loop
+		b.RequestRefresh()
+	} else {
+		b.convertIDsToNames(api)
+	}
+	errs := b.resolveIDs()
 
-}
+	r := b.roles()
+	log.Printf("Admins 2: %v len %v cap %v\n", r.Admins, len(r.Admins), cap(r.Admins))
+	log.Printf("Allowed 2: %v  len %v cap %v\n", r.AllowedUsers, len(r.AllowedUsers), cap(r.AllowedUsers))
 
-func (b *BlockConfig) convertIDsToNames(api *slack.Client) {
+	b.loadState()
 
-	// TODO - refresh users and channels every 1h !!!! or on demand (refresh command)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("WARNING! Block config: %v - entry ignored", err)
+		}
+		if os.Getenv("SLACKBOT_STRICT_CONFIG") == "true" {
+			return fmt.Errorf("%d configured name(s) not found in Slack", len(errs))
+		}
+	}
+	return nil
+}
 
-	b.users.GetUsers(api)
+// convertIDsToNames - get users, channels and user groups from Slack and save them for next start
+func (b *BlockConfig) convertIDsToNames(api *slack.Client) {
 
-	b.groups.GetChannels(api)
+	usersErr := b.users.GetUsers(api)
 
-	// convert names to ID's
-	b.Channel.ID = b.groups.NameToID(b.Channel.Name[1:])
+	groupsErr := b.groups.GetChannels(api)
 
-	b.Owner.ID = b.users.NameToID(b.Owner.Name[1:])
+	// Needs usergroups:read scope and paid plan - without them roles can be users only,
+	// so failure doesn't stop users and channels from being cached
+	if err := b.usergroups.GetUserGroups(api); err != nil {
+		log.Printf("User groups not available (usergroups:read scope and paid plan needed), last known kept: %v", err)
+	}
 
-	for i := range b.Admins {
-		b.Admins[i].ID = b.users.NameToID(b.Admins[i].Name[1:])
+	if usersErr == nil && groupsErr == nil {
+		b.saveDirectory()
 	}
-	for i := range b.AllowedUsers {
-		b.AllowedUsers[i].ID = b.users.NameToID(b.AllowedUsers[i].Name[1:])
+
+	// Member events come only from channels the bot is in - refetch cached members of the others
+	for _, id := range b.groups.MemberChannels() {
+		if _, err := b.groups.GetMembers(api, id); err != nil {
+			log.Printf("Error getting members of %s: %v", id, err)
+		}
 	}
+}
 
+// isMember - true if user is the entry or belongs to user group given as the entry.
+// Unresolved entries have empty ID, so empty id (e.g. bot message without user) never matches
+func (b *BlockConfig) isMember(n NameID, id string) bool {
+	if id == "" || n.ID == "" {
+		return false
+	}
+	return n.ID == id || b.usergroups.IsMember(n.ID, id)
 }
 
+// IsOwner - roles can be users or user groups
 func (b *BlockConfig) IsOwner(id string) bool {
-	return b.Owner.ID == id
+	return b.isMember(b.roles().Owner, id)
 }
 
 func (b *BlockConfig) IsAdmin(id string) bool {
-	for i := range b.Admins {
-		if b.Admins[i].ID == id {
+	for _, n := range b.roles().Admins {
+		if b.isMember(n, id) {
 			return true
 		}
 	}
@@ -95,8 +183,16 @@ func (b *BlockConfig) IsAdmin(id string) bool {
 }
 
 func (b *BlockConfig) IsAllowedUser(id string) bool {
-	for i := range b.AllowedUsers {
-		if b.AllowedUsers[i].ID == id {
+	for _, n := range b.roles().AllowedUsers {
+		if b.isMember(n, id) {
+			return true
+		}
+	}
+
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	for i := range b.state.AllowedUsers {
+		if b.isMember(b.state.AllowedUsers[i], id) {
 			return true
 		}
 	}
@@ -104,8 +200,9 @@ func (b *BlockConfig) IsAllowedUser(id string) bool {
 }
 
 func (b *BlockConfig) IsBlockedChannel(id string) bool {
-	log.Printf("Config blocked: %s (%s), mgs channel: %s (%s)\n", b.Channel.ID, b.groups.IDToName(b.Channel.ID), id, b.groups.IDToName(id))
-	return b.Channel.ID == id
+	channel := b.BlockedChannel()
+	log.Printf("Config blocked: %s (%s), mgs channel: %s (%s)\n", channel.ID, b.ChannelName(channel.ID), id, b.ChannelName(id))
+	return channel.ID != "" && channel.ID == id
 }
 
 func (b *BlockConfig) IsAllowedWrite(id string) bool {
@@ -116,22 +213,174 @@ func (b *BlockConfig) IsAllowedWrite(id string) bool {
 func (b *BlockConfig) IsNotAllowedWrite(id string) bool {
 	return !b.IsAllowedWrite(id)
 }
+
+// Directory - lookups in users, channels and user groups known to the bot
+func (b *BlockConfig) Directory() utils.Directory {
+	return &utils.SlackDirectory{Users: &b.users, Channels: &b.groups, UserGroups: &b.usergroups}
+}
+
+// ChannelID - translate channel given as #name, name or <#C123|name> to ID. Empty if unknown
+func (b *BlockConfig) ChannelID(ref string) string {
+	e, err := b.Directory().Lookup(ref)
+	if err != nil || e.Kind != utils.EntityChannel {
+		return ""
+	}
+	return e.ID
+}
+
+// ChannelName - translate channel ID to name (ID itself if unknown, so it is still usable in messages)
+func (b *BlockConfig) ChannelName(id string) string {
+	name, err := b.groups.IDToName(id)
+	if err != nil {
+		return id
+	}
+	return name
+}
+
 func (b *BlockConfig) AdminNames() []string {
 	var admins []string
-	for i := range b.Admins {
-		admins = append(admins, b.Admins[i].Name)
+	for _, n := range b.roles().Admins {
+		admins = append(admins, n.Name)
 	}
 	return admins
 }
 
 func (b *BlockConfig) AllowedUsersNames() []string {
 	var users []string
-	for i := range b.AllowedUsers {
-		users = append(users, b.AllowedUsers[i].Name)
+	for _, n := range b.roles().AllowedUsers {
+		users = append(users, n.Name)
+	}
+
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	for _, u := range b.state.AllowedUsers {
+		// Show current name - user or group could be renamed since it was added
+		if e, err := b.Directory().Lookup(u.ID); err == nil {
+			u.Name = e.String()
+		}
+		users = append(users, u.Name)
 	}
 	return users
 }
 
+// LookupMember - find user or user group given as @name, display name, real name, email, <@U123>,
+// <!subteam^S123> or ID. Error suggests similar names if nothing matches
+func (b *BlockConfig) LookupMember(ref string) (NameID, error) {
+	e, err := b.Directory().Lookup(ref)
+	if err != nil {
+		return NameID{}, err
+	}
+	if e.Kind != utils.EntityUser && e.Kind != utils.EntityUserGroup {
+		return NameID{}, fmt.Errorf("%s is a %s, not a user or user group", ref, e.Kind)
+	}
+	return NameID{Name: e.String(), ID: e.ID}, nil
+}
+
+// AddAllowedUser - allow user or user group to write to the blocked channel (kept between restarts)
+func (b *BlockConfig) AddAllowedUser(ref string) (NameID, error) {
+	user, err := b.LookupMember(ref)
+	if err != nil {
+		return NameID{}, err
+	}
+	configured := b.roles().AllowedUsers
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	for _, list := range [][]NameID{configured, b.state.AllowedUsers} {
+		for _, n := range list {
+			if n.ID == user.ID {
+				return user, fmt.Errorf("%s is already allowed", user.Name)
+			}
+		}
+	}
+	b.state.AllowedUsers = append(b.state.AllowedUsers, user)
+	b.saveState()
+	return user, nil
+}
+
+// RemoveAllowedUser - remove user added with AddAllowedUser. Users configured in environment can't be removed
+func (b *BlockConfig) RemoveAllowedUser(ref string) (NameID, error) {
+	user, err := b.LookupMember(ref)
+	if err != nil {
+		return NameID{}, err
+	}
+
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	for i := range b.state.AllowedUsers {
+		if b.state.AllowedUsers[i].ID == user.ID {
+			b.state.AllowedUsers = append(b.state.AllowedUsers[:i], b.state.AllowedUsers[i+1:]...)
+			b.saveState()
+			return user, nil
+		}
+	}
+	return user, fmt.Errorf("%s not found (users configured in environment can't be removed)", user.Name)
+}
+
+// moderateMessage - delete message from the blocked channel if its author is not allowed to write there.
+// Used for live RTM events and for messages found by the history scan. Returns true if message was deleted
+func (b *BlockConfig) moderateMessage(msg *slack.Msg) bool {
+	// Only empty subtype (ordinary message) or "bot_message" are passed through
+	// to be deleted
+	// If Hidden - do nothing - because users don't see it
+	if (len(msg.SubType) != 0 && msg.SubType != "bot_message") || msg.Hidden {
+		return false
+	}
+
+	if !b.IsBlockedChannel(msg.Channel) || b.isAllowedMessage(msg) {
+		return false
+	}
+
+	if slackbotRTMDebug || true {
+		log.Printf("Message to delete: %s\n", utils.StructPrettyPrint(msg))
+	}
+	schan, ts, err := b.api.DeleteMessage(msg.Channel, msg.Timestamp)
+	if err != nil {
+		log.Printf("Error deleting message. Chan: %s, ts: %s, err: %v\n", schan, ts, err)
+		return false
+	}
+	if msg.SubType == "bot_message" {
+		// Don't answer to bot
+		return true
+	}
+
+	b.notifyDeleted(msg, fmt.Sprintf("Your message was deleted from %s channel. You are not allowed to publish there. %s", mrkdwn.Channel(b.BlockedChannel().ID), b.DeletedMsg))
+	return true
+}
+
+// notifyDeleted - send author of a deleted message the reason and the deleted text as direct message
+// and/or ephemeral message in the channel (SLACKBOT_DELETED_NOTICE). Queued with retries
+func (b *BlockConfig) notifyDeleted(msg *slack.Msg, reason string) {
+	deleted := robots.Attachment{
+		Fallback:   "Deleted message: " + msg.Text,
+		Color:      robots.ColorDanger,
+		Pretext:    "Deleted message is below:",
+		AuthorName: b.UserName(msg.User),
+		Text:       msg.Text,
+		Footer:     "#" + b.ChannelName(msg.Channel),
+	}
+	deleted.SetTime(tsTime(msg.Timestamp))
+	notice := robots.Message{
+		Username:    "Block Bot",
+		Text:        reason,
+		Attachments: []robots.Attachment{deleted},
+	}
+
+	if b.DeletedNotice == noticeChannel || b.DeletedNotice == noticeBoth {
+		robots.SendEphemeral(msg.Channel, msg.User, notice)
+	}
+	if b.DeletedNotice == noticeDM || b.DeletedNotice == noticeBoth || b.DeletedNotice == "" {
+		// Posting to user ID opens DM with the user. "@name" channels no longer work
+		notice.Channel = msg.User
+		robots.SenderFor("").Send(notice)
+	}
+}
+
+// Shutdown - save state kept in memory. Call before exit
+func Shutdown() {
+	Config.FlushState()
+	Digest.Flush()
+}
+
 // RunRTM - listen to RTM events and remove messages
 func RunRTM() {
 	apiToken := os.Getenv("SLACKBOT_API_TOKEN")
@@ -141,7 +390,6 @@ func RunRTM() {
 		return
 	}
 
-	slackbotRTMDebug := false
 	if os.Getenv("SLACKBOT_RTM_DEBUG") == "true" {
 		slackbotRTMDebug = true
 	}
@@ -150,14 +398,42 @@ func RunRTM() {
 	// api.SetDebug(true)
 
 	// FIXME: Global variable Config
-	Config.ReadBlockChannelConfig(api)
+	if err := Config.ReadBlockChannelConfig(api); err != nil {
+		log.Printf("Block config error: %v. RTM module not run", err)
+		return
+	}
+
+	Retention.ReadRetentionConfig(api)
+	go Retention.Run()
+
+	Burner.Start(api)
+
+	Digest.Start(api)
+
+	go Config.flushStateLoop()
+	defer Shutdown()
 
 	rtm := api.NewRTM()
 	go rtm.ManageConnection()
 
+	refresh := time.NewTicker(directoryRefreshInterval())
+	defer refresh.Stop()
+
+	// teamDomain - from connect info, robots triggered by messages reply with sender of the domain
+	teamDomain := ""
+
 Loop:
 	for {
 		select {
+		case <-refresh.C:
+			Config.refresh()
+
+		case <-refreshRequests:
+			Config.refresh()
+
+		case <-refreshDone:
+			Config.refreshed()
+
 		case msg := <-rtm.IncomingEvents:
 
 			// Print pretty events
@@ -171,52 +447,43 @@ Loop:
 
 			case *slack.ConnectedEvent:
 				// rtm.SendMessage(rtm.NewOutgoingMessage("Hello world", "C0FCTCZNK"))
+				// Connected on startup and after every reconnect - moderate messages posted in the gap.
+				// The mark is read now: live messages handled while the scan starts would move it past the gap
+				go Config.catchUp(Config.LastProcessed())
+				Presence.connected(rtm)
+				if ev.Info != nil && ev.Info.Team != nil {
+					teamDomain = ev.Info.Team.Domain
+				}
 
 			case *slack.AckMessage:
 				// log.Println("Ack:", ev.Info)
 
 			case *slack.MessageEvent:
-				if (len(ev.SubType) == 0 || ev.SubType == "bot_message") && !ev.Hidden {
-					// Only empty subtype (ordinary message) or "bot_message" are passed through
-					// to be deleted
-					// If Hidden - do nothing - because users don't see it
-
-					if Config.IsBlockedChannel(ev.Channel) && Config.IsNotAllowedWrite(ev.User) {
-						if slackbotRTMDebug || true {
-							log.Printf("Message to delete: %s\n", utils.StructPrettyPrint(msg))
-						}
-						schan, ts, err := api.DeleteMessage(ev.Channel, ev.Timestamp)
-						if err != nil {
-							log.Printf("Error deleting message. Chan: %s, ts: %s, err: %v\n", schan, ts, err)
-						} else {
-							if ev.SubType == "bot_message" {
-								// Don't answer to bot
-								break
-							}
-
-							replyUser, _ := api.GetUserInfo(ev.User)
-							replyUserAtName := "@" + replyUser.Name
-
-							if slackbotRTMDebug {
-								log.Printf("Event Received: %s\n", utils.StructPrettyPrint(replyUser))
-							}
-
-							params := slack.PostMessageParameters{AsUser: false, Username: "Block Bot"}
-							attachment := slack.Attachment{
-								Pretext: "Deleted message is below:",
-								Text:    ev.Text,
-							}
-
-							params.Attachments = []slack.Attachment{attachment}
-							// channelID, timestamp, err := api.PostMessage(replyToChannel,
-							api.PostMessage(replyUserAtName,
-								fmt.Sprintf("Your message was deleted from '%s' channel. You are not allowed to publish there. %s", Config.Channel.Name, Config.DeletedMsg), params)
-						}
-					}
+				bots.record(&ev.Msg)
+				deleted := false
+				if Config.IsBlockedChannel(ev.Channel) {
+					deleted = Config.moderateMessage(&ev.Msg)
+					Config.markProcessed(ev.Timestamp)
+				}
+				if !deleted {
+					deleted = Config.slowDown(&ev.Msg)
+				}
+				if !deleted {
+					Digest.collect(&ev.Msg)
+					robots.RunMessage(&ev.Msg, teamDomain)
 				}
+			case *slack.TeamJoinEvent, *slack.UserChangeEvent,
+				*slack.ChannelCreatedEvent, *slack.ChannelJoinedEvent, *slack.ChannelRenameEvent, *slack.ChannelDeletedEvent,
+				*slack.GroupCreatedEvent, *slack.GroupJoinedEvent, *slack.GroupRenameEvent, *slack.IMCreatedEvent,
+				*slack.SubteamCreatedEvent, *slack.SubteamUpdatedEvent, *slack.SubteamMembersChangedEvent,
+				*slack.MemberJoinedChannelEvent, *slack.MemberLeftChannelEvent:
+				Config.updateDirectory(ev)
+
+			case *slack.ReactionAddedEvent:
+				Burner.onReaction(ev)
+
 			case *slack.PresenceChangeEvent:
-				Config.users.SetPresenceByID(ev.User, ev.Presence)
-				// log.Printf("Presence Change: User: %s %s\n", users.IDToName(ev.User), users.GetPresenceByID(ev.User))
+				Presence.onPresenceChange(ev)
 
 			case *slack.LatencyReport:
 				// log.Printf("Current latency: %v\n", ev.Value)
@@ -0,0 +1,211 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/utils"
+)
+
+const (
+	blockStateFile  = "block_state.json"
+	historyPageSize = 100
+	// high-water mark moves with every message - it is written at most this often
+	stateFlushInterval = 10 * time.Second
+)
+
+// blockState - moderation state kept between restarts
+type blockState struct {
+	// LastTS - high-water mark: timestamp of the last processed message per channel ID
+	LastTS map[string]string `json:"last_ts"`
+	// AllowedUsers - users added with /block add
+	AllowedUsers []NameID `json:"allowed_users,omitempty"`
+	// AllowedBots - bot allow-list entries added with /block allowbot
+	AllowedBots []BotEntry `json:"allowed_bots,omitempty"`
+	// SlowMode - posting limits per channel ID set with /block slow
+	SlowMode map[string]SlowMode `json:"slow_mode,omitempty"`
+}
+
+func (b *BlockConfig) loadState() {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	if err := utils.LoadJSON(utils.DataPath(blockStateFile), &b.state); err != nil {
+		log.Printf("Error loading block state: %v", err)
+	}
+	if b.state.LastTS == nil {
+		b.state.LastTS = make(map[string]string)
+	}
+}
+
+// saveState - caller must hold stateMu
+func (b *BlockConfig) saveState() {
+	if err := utils.SaveJSON(utils.DataPath(blockStateFile), &b.state); err != nil {
+		log.Printf("Error saving block state: %v", err)
+		return
+	}
+	b.stateDirty = false
+}
+
+// FlushState - save state if high-water mark moved since last save
+func (b *BlockConfig) FlushState() {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	if b.stateDirty {
+		b.saveState()
+	}
+}
+
+// flushStateLoop - save moved high-water mark every stateFlushInterval
+func (b *BlockConfig) flushStateLoop() {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.FlushState()
+	}
+}
+
+// LastProcessed - timestamp of the last processed message in the blocked channel ("" if none)
+func (b *BlockConfig) LastProcessed() string {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	return b.state.LastTS[b.BlockedChannel().ID]
+}
+
+// markProcessed - move high-water mark of the blocked channel forward to ts. It is saved by flushStateLoop
+// and on Shutdown, so busy channels and scans don't rewrite the state file for every message
+func (b *BlockConfig) markProcessed(ts string) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	if b.state.LastTS == nil {
+		b.state.LastTS = make(map[string]string)
+	}
+	channelID := b.BlockedChannel().ID
+	if !tsAfter(ts, b.state.LastTS[channelID]) {
+		return
+	}
+	b.state.LastTS[channelID] = ts
+	b.stateDirty = true
+}
+
+// catchUp - moderate messages posted after since - the high-water mark read when the connection came up
+// (e.g. messages posted while the bot was down or reconnecting)
+func (b *BlockConfig) catchUp(since string) {
+	b.catchUpMu.Lock()
+	defer b.catchUpMu.Unlock()
+
+	if since == "" {
+		// First run - there is no gap to fill, start watching from now
+		b.markProcessed(toTimestamp(time.Now()))
+		log.Printf("Catch-up scan skipped - no previous high-water mark for %s", b.BlockedChannel().Name)
+		return
+	}
+
+	scanned, deleted, err := b.ScanHistory(since)
+	if err != nil {
+		log.Printf("Catch-up scan of %s failed: %v", b.BlockedChannel().Name, err)
+		return
+	}
+	log.Printf("Catch-up scan of %s since %s: scanned %d, deleted %d", b.BlockedChannel().Name, since, scanned, deleted)
+}
+
+// ScanHistory - apply moderation policy to blocked channel messages posted after oldest (Slack timestamp)
+func (b *BlockConfig) ScanHistory(oldest string) (scanned int, deleted int, err error) {
+	if b.api == nil {
+		return 0, 0, fmt.Errorf("RTM module not running")
+	}
+
+	var messages []slack.Message
+	channelID := b.BlockedChannel().ID
+
+	params := slack.NewHistoryParameters()
+	params.Oldest = oldest
+	params.Count = historyPageSize
+
+	for {
+		history, err := channelHistory(b.api, channelID, params)
+		if err != nil {
+			return 0, 0, err
+		}
+		messages = append(messages, history.Messages...)
+		if !history.HasMore || len(history.Messages) == 0 {
+			break
+		}
+		// Messages are returned newest first - continue below the oldest one
+		params.Latest = history.Messages[len(history.Messages)-1].Timestamp
+	}
+
+	// Moderate in posting order so the high-water mark only moves forward
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i].Msg
+		msg.Channel = channelID
+		scanned++
+		if b.moderateMessage(&msg) {
+			deleted++
+		}
+		b.markProcessed(msg.Timestamp)
+	}
+	return scanned, deleted, nil
+}
+
+// channelHistory - one page of conversation history (conversations.history, works for all conversation kinds)
+func channelHistory(api *slack.Client, channelID string, params slack.HistoryParameters) (*slack.History, error) {
+	resp, err := api.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Latest:    params.Latest,
+		Oldest:    params.Oldest,
+		Limit:     params.Count,
+		Inclusive: params.Inclusive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &slack.History{Latest: resp.Latest, Messages: resp.Messages, HasMore: resp.HasMore}, nil
+}
+
+// ParseSince - convert scan start given by user to Slack timestamp.
+// Accepts "last" (high-water mark), duration ago ("90m", "2h"), date ("2016-01-31") or Slack timestamp
+func (b *BlockConfig) ParseSince(since string) (string, error) {
+	if since == "" || since == "last" {
+		ts := b.LastProcessed()
+		if ts == "" {
+			return "", fmt.Errorf("no messages processed yet - give a time")
+		}
+		return ts, nil
+	}
+
+	if d, err := time.ParseDuration(since); err == nil {
+		return toTimestamp(time.Now().Add(-d)), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", since, time.Local); err == nil {
+		return toTimestamp(t), nil
+	}
+	if _, err := strconv.ParseFloat(since, 64); err == nil {
+		return since, nil
+	}
+	return "", fmt.Errorf("can't understand time '%s' - use e.g. 2h, 2016-01-31 or Slack timestamp", since)
+}
+
+func toTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+// tsAfter - true if Slack timestamp a is later than b (empty b is earlier than anything)
+func tsAfter(a, b string) bool {
+	fa, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	fb, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return true
+	}
+	return fa > fb
+}
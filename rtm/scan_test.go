@@ -0,0 +1,65 @@
+package rtm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// historyServer - empty conversations.history, records oldest of every request
+func historyServer(t *testing.T) (*slack.Client, func() []string) {
+	var mu sync.Mutex
+	var oldest []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		oldest = append(oldest, r.FormValue("oldest"))
+		mu.Unlock()
+		fmt.Fprint(w, `{"ok":true,"messages":[],"has_more":false}`)
+	}))
+	t.Cleanup(srv.Close)
+	return slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/")), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), oldest...)
+	}
+}
+
+func TestCatchUpScansFromMarkAtConnect(t *testing.T) {
+	b := testConfig()
+	b.resolveIDs()
+	api, requests := historyServer(t)
+	b.api = api
+	b.markProcessed("1500000000.000100")
+
+	// Connected: mark is read, then live messages move it before the scan goroutine runs
+	since := b.LastProcessed()
+	b.markProcessed("1500000900.000100")
+	b.catchUp(since)
+
+	got := requests()
+	if len(got) != 1 || got[0] != "1500000000.000100" {
+		t.Errorf("history requested with oldest %q, want [1500000000.000100]", got)
+	}
+	if last := b.LastProcessed(); last != "1500000900.000100" {
+		t.Errorf("high-water mark = %s, catch-up must not move it back", last)
+	}
+}
+
+func TestCatchUpFirstRunSetsMark(t *testing.T) {
+	b := testConfig()
+	b.resolveIDs()
+	api, requests := historyServer(t)
+	b.api = api
+
+	b.catchUp("")
+	if got := requests(); len(got) != 0 {
+		t.Errorf("first run scanned history: %q", got)
+	}
+	if b.LastProcessed() == "" {
+		t.Error("first run did not set the high-water mark")
+	}
+}
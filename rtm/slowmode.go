@@ -0,0 +1,177 @@
+package rtm
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/wojtekzw/slackbot/mrkdwn"
+)
+
+// SlowMode - per user posting limit in a channel: at most Limit top-level messages per Window
+type SlowMode struct {
+	Limit  int           `json:"limit"`
+	Window time.Duration `json:"window"`
+}
+
+func (s SlowMode) String() string {
+	return fmt.Sprintf("%d message(s) per %s", s.Limit, s.Window)
+}
+
+// postLog - timestamps of recent top-level messages per channel and user
+type postLog struct {
+	mu    sync.Mutex
+	posts map[string]map[string][]time.Time
+}
+
+var slowPosts = &postLog{posts: make(map[string]map[string][]time.Time)}
+
+// add - register post at t if it fits the limit. If not, returns time when user can post again
+func (l *postLog) add(channelID, userID string, t time.Time, mode SlowMode) (ok bool, next time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	users, found := l.posts[channelID]
+	if !found {
+		users = make(map[string][]time.Time)
+		l.posts[channelID] = users
+	}
+
+	// Forget users with no posts inside the window, memory doesn't grow with everyone who ever posted
+	for id, posts := range users {
+		if id != userID && (len(posts) == 0 || t.Sub(posts[len(posts)-1]) >= mode.Window) {
+			delete(users, id)
+		}
+	}
+
+	// Keep only posts inside the window
+	var recent []time.Time
+	for _, p := range users[userID] {
+		if t.Sub(p) < mode.Window {
+			recent = append(recent, p)
+		}
+	}
+
+	if len(recent) >= mode.Limit {
+		users[userID] = recent
+		return false, recent[len(recent)-mode.Limit].Add(mode.Window)
+	}
+	users[userID] = append(recent, t)
+	return true, time.Time{}
+}
+
+// forget - drop posts in channel (slow mode turned off or changed)
+func (l *postLog) forget(channelID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.posts, channelID)
+}
+
+// SetSlowMode - limit posting in channel (kept between restarts). Limit 0 turns slow mode off
+func (b *BlockConfig) SetSlowMode(channelID string, mode SlowMode) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	if b.state.SlowMode == nil {
+		b.state.SlowMode = make(map[string]SlowMode)
+	}
+	if mode.Limit <= 0 {
+		delete(b.state.SlowMode, channelID)
+		slowPosts.forget(channelID)
+	} else {
+		b.state.SlowMode[channelID] = mode
+	}
+	b.saveState()
+}
+
+// SlowModeFor - slow mode settings of channel. ok is false if channel has no slow mode
+func (b *BlockConfig) SlowModeFor(channelID string) (mode SlowMode, ok bool) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	mode, ok = b.state.SlowMode[channelID]
+	return mode, ok
+}
+
+// SlowModeChannels - channel IDs with slow mode on, sorted
+func (b *BlockConfig) SlowModeChannels() []string {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	var ids []string
+	for id := range b.state.SlowMode {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ParseSlowMode - parse limit and window given as "3 10m"
+func ParseSlowMode(limit, window string) (SlowMode, error) {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return SlowMode{}, fmt.Errorf("limit must be a positive number, got '%s'", limit)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return SlowMode{}, fmt.Errorf("window must be a duration like 10m or 1h, got '%s'", window)
+	}
+	return SlowMode{Limit: n, Window: d}, nil
+}
+
+// slowDown - delete top-level message over the slow mode limit of its channel and tell the author when to post again.
+// Owner, admins and allowed users are not limited. Returns true if message was deleted
+func (b *BlockConfig) slowDown(msg *slack.Msg) bool {
+	if len(msg.SubType) != 0 || msg.Hidden || msg.User == "" {
+		return false
+	}
+	// Thread replies are not limited
+	if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+		return false
+	}
+
+	mode, ok := b.SlowModeFor(msg.Channel)
+	if !ok || b.IsOwner(msg.User) || b.IsAdmin(msg.User) || b.IsAllowedUser(msg.User) {
+		return false
+	}
+
+	posted := tsTime(msg.Timestamp)
+	ok, next := slowPosts.add(msg.Channel, msg.User, posted, mode)
+	if ok {
+		return false
+	}
+
+	log.Printf("Slow mode - message to delete: chan %s, user %s, ts %s\n", msg.Channel, msg.User, msg.Timestamp)
+	schan, ts, err := b.api.DeleteMessage(msg.Channel, msg.Timestamp)
+	if err != nil {
+		log.Printf("Error deleting message. Chan: %s, ts: %s, err: %v\n", schan, ts, err)
+		return false
+	}
+
+	wait := next.Sub(posted)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	b.notifyDeleted(msg, fmt.Sprintf("Your message was deleted from %s channel. Slow mode allows %s. You can post again in %s (at %s).",
+		mrkdwn.Channel(msg.Channel), mode, wait.Round(time.Second), mrkdwn.Date(next, mrkdwn.TimeSecs)))
+	return true
+}
+
+// tsTime - convert Slack timestamp to time (now if it can't be parsed)
+func tsTime(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	var usec int64
+	if len(parts) == 2 {
+		usec, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(sec, usec*1000)
+}
@@ -0,0 +1,95 @@
+package rtm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestPostLogAdd(t *testing.T) {
+	start := time.Date(2016, 1, 31, 12, 0, 0, 0, time.UTC)
+	mode := SlowMode{Limit: 2, Window: time.Minute}
+	type post struct {
+		user   string
+		after  time.Duration
+		ok     bool
+		waitTo time.Duration
+	}
+	tests := []struct {
+		name  string
+		posts []post
+		// users - users left in the channel log after the last post
+		users int
+	}{
+		{"under limit", []post{
+			{"U1", 0, true, 0},
+			{"U1", 10 * time.Second, true, 0},
+		}, 1},
+		{"over limit waits for oldest post to leave window", []post{
+			{"U1", 0, true, 0},
+			{"U1", 10 * time.Second, true, 0},
+			{"U1", 20 * time.Second, false, time.Minute},
+		}, 1},
+		{"rejected post doesn't count", []post{
+			{"U1", 0, true, 0},
+			{"U1", 10 * time.Second, true, 0},
+			{"U1", 20 * time.Second, false, time.Minute},
+			{"U1", 61 * time.Second, true, 0},
+		}, 1},
+		{"window end is outside", []post{
+			{"U1", 0, true, 0},
+			{"U1", 10 * time.Second, true, 0},
+			{"U1", time.Minute, true, 0},
+		}, 1},
+		{"users are limited separately", []post{
+			{"U1", 0, true, 0},
+			{"U1", 1 * time.Second, true, 0},
+			{"U2", 2 * time.Second, true, 0},
+			{"U2", 3 * time.Second, true, 0},
+		}, 2},
+		{"users outside window are pruned", []post{
+			{"U1", 0, true, 0},
+			{"U2", 40 * time.Second, true, 0},
+			{"U3", 90 * time.Second, true, 0},
+		}, 2},
+		{"all other users pruned", []post{
+			{"U1", 0, true, 0},
+			{"U2", 10 * time.Second, true, 0},
+			{"U3", 5 * time.Minute, true, 0},
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &postLog{posts: make(map[string]map[string][]time.Time)}
+			for i, p := range tt.posts {
+				ok, next := l.add("C1", p.user, start.Add(p.after), mode)
+				if ok != p.ok {
+					t.Fatalf("post %d: ok = %t, want %t", i, ok, p.ok)
+				}
+				if !ok && !next.Equal(start.Add(p.waitTo)) {
+					t.Errorf("post %d: next = %s, want %s", i, next, start.Add(p.waitTo))
+				}
+			}
+			if n := len(l.posts["C1"]); n != tt.users {
+				t.Errorf("log keeps %d users, want %d", n, tt.users)
+			}
+		})
+	}
+}
+
+func TestSlowDownSkipsAllowedUsers(t *testing.T) {
+	b := testConfig()
+	b.users.SetUser(slack.User{ID: "U0000ALWD", Name: "allowed"})
+	b.AllowedUsers = []NameID{{Name: "allowed"}}
+	b.resolveIDs()
+	b.state.SlowMode = map[string]SlowMode{"C0000SLOW": {Limit: 1, Window: time.Hour}}
+
+	// No API client - a limited message would need it to delete
+	for i, ts := range []string{"1500000000.000100", "1500000001.000100", "1500000002.000100"} {
+		msg := &slack.Msg{Channel: "C0000SLOW", User: "U0000ALWD", Timestamp: ts}
+		if b.slowDown(msg) {
+			t.Fatalf("message %d of allowed user deleted", i)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// directoryCacheVersion - bumped when cache format changes, older files are ignored
+const directoryCacheVersion = 3
+
+// directoryCache - users, channels and user groups saved to disk, so names resolve right after start
+// and when Slack is unreachable
+type directoryCache struct {
+	Version       int               `json:"version"`
+	Saved         time.Time         `json:"saved"`
+	Users         []slack.User      `json:"users"`
+	Conversations []slack.Channel   `json:"conversations"`
+	UserGroups    []slack.UserGroup `json:"usergroups"`
+}
+
+// SaveDirectory - write current users, channels and user groups to file
+func SaveDirectory(path string, users *GlobalUsers, channels *GlobalChannels, groups *GlobalUserGroups) error {
+	u := users.load()
+	c := channels.load()
+	g := groups.load()
+
+	return SaveJSON(path, &directoryCache{
+		Version:       directoryCacheVersion,
+		Saved:         time.Now(),
+		Users:         u.users,
+		Conversations: c.conversations,
+		UserGroups:    g.groups,
+	})
+}
+
+// LoadDirectory - read users, channels and user groups saved by SaveDirectory. Returns time the file was saved
+func LoadDirectory(path string, users *GlobalUsers, channels *GlobalChannels, groups *GlobalUserGroups) (time.Time, error) {
+	var cache directoryCache
+	if err := LoadJSON(path, &cache); err != nil {
+		return time.Time{}, err
+	}
+	if cache.Saved.IsZero() {
+		return time.Time{}, fmt.Errorf("no directory cache in %s", path)
+	}
+	if cache.Version != directoryCacheVersion {
+		return time.Time{}, fmt.Errorf("directory cache in %s has old format %d", path, cache.Version)
+	}
+
+	users.replace(cache.Users)
+	channels.replace(cache.Conversations)
+	groups.replace(cache.UserGroups)
+	return cache.Saved, nil
+}
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntityKind - what a name or ID refers to
+type EntityKind string
+
+// Entity kinds
+const (
+	EntityUnknown   EntityKind = ""
+	EntityUser      EntityKind = "user"
+	EntityChannel   EntityKind = "channel"
+	EntityUserGroup EntityKind = "usergroup"
+)
+
+// Entity - resolved user, channel or user group
+type Entity struct {
+	Kind EntityKind
+	ID   string
+	Name string
+}
+
+func (e Entity) String() string {
+	switch e.Kind {
+	case EntityUser:
+		return "@" + e.Name
+	case EntityChannel:
+		return "#" + e.Name
+	case EntityUserGroup:
+		// Slack shows user groups like users - @platform-team
+		return "@" + e.Name
+	}
+	return e.Name
+}
+
+// NotFoundError - name or ID not found in the directory
+type NotFoundError struct {
+	Kind EntityKind
+	Name string
+	ID   string
+	// Suggestions - similar names (e.g. @jan.kowalski for "jan"), best first
+	Suggestions []string
+}
+
+func (e *NotFoundError) Error() string {
+	kind := string(e.Kind)
+	if e.Kind == EntityUnknown {
+		kind = "user, channel or user group"
+	}
+	if e.ID != "" {
+		return fmt.Sprintf("unknown %s ID %s", kind, e.ID)
+	}
+	if len(e.Suggestions) > 0 {
+		return fmt.Sprintf("unknown %s %s - did you mean %s?", kind, e.Name, strings.Join(e.Suggestions, ", "))
+	}
+	return fmt.Sprintf("unknown %s %s", kind, e.Name)
+}
+
+// IsNotFound - true if err is a directory miss
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// Directory - lookups of users, channels and user groups
+type Directory interface {
+	UserID(name string) (string, error)
+	UserName(id string) (string, error)
+	ChannelID(name string) (string, error)
+	ChannelName(id string) (string, error)
+	UserGroupID(handle string) (string, error)
+	UserGroupName(id string) (string, error)
+	// IsMember - true if user belongs to user group
+	IsMember(groupID string, userID string) bool
+	// Lookup - resolve reference written as @name, #name, <@U123>, <#C123|name>, <!subteam^S123> or bare ID/name
+	Lookup(ref string) (Entity, error)
+	// Resolve - Lookup of many references. All found entities are returned, errors are collected in ResolveErrors
+	Resolve(refs []string) ([]Entity, error)
+}
+
+// ResolveErrors - references which could not be resolved by Directory.Resolve
+type ResolveErrors []error
+
+func (e ResolveErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// SlackDirectory - Directory backed by cached users, channels and user groups
+type SlackDirectory struct {
+	Users      *GlobalUsers
+	Channels   *GlobalChannels
+	UserGroups *GlobalUserGroups
+}
+
+// UserID - ID of user with given username, display name, real name or email (with or without @)
+func (d *SlackDirectory) UserID(name string) (string, error) {
+	return d.Users.NameToID(strings.TrimPrefix(name, "@"))
+}
+
+// UserName - name of user with given ID
+func (d *SlackDirectory) UserName(id string) (string, error) {
+	return d.Users.IDToName(id)
+}
+
+// ChannelID - ID of channel with given name (with or without #)
+func (d *SlackDirectory) ChannelID(name string) (string, error) {
+	return d.Channels.NameToID(strings.TrimPrefix(name, "#"))
+}
+
+// ChannelName - name of channel with given ID
+func (d *SlackDirectory) ChannelName(id string) (string, error) {
+	return d.Channels.IDToName(id)
+}
+
+// UserGroupID - ID of user group with given handle (with or without @)
+func (d *SlackDirectory) UserGroupID(handle string) (string, error) {
+	return d.UserGroups.NameToID(strings.TrimPrefix(handle, "@"))
+}
+
+// UserGroupName - handle of user group with given ID
+func (d *SlackDirectory) UserGroupName(id string) (string, error) {
+	return d.UserGroups.IDToName(id)
+}
+
+// IsMember - true if user belongs to user group
+func (d *SlackDirectory) IsMember(groupID string, userID string) bool {
+	return d.UserGroups.IsMember(groupID, userID)
+}
+
+// Lookup - resolve single reference to user, channel or user group
+func (d *SlackDirectory) Lookup(ref string) (Entity, error) {
+	kind, id, name := ParseRef(ref)
+
+	switch kind {
+	case EntityUser:
+		if id == "" {
+			var err error
+			if id, err = d.UserID(name); err != nil {
+				// @name can be a user group too
+				groupID, groupErr := d.UserGroupID(name)
+				if groupErr == nil {
+					return Entity{Kind: EntityUserGroup, ID: groupID, Name: name}, nil
+				}
+				return Entity{}, &NotFoundError{Kind: EntityUser, Name: name,
+					Suggestions: append(suggestionsOf(err), suggestionsOf(groupErr)...)}
+			}
+		}
+		name, err := d.UserName(id)
+		return Entity{Kind: EntityUser, ID: id, Name: name}, err
+
+	case EntityChannel:
+		if id == "" {
+			var err error
+			if id, err = d.ChannelID(name); err != nil {
+				return Entity{}, err
+			}
+		}
+		name, err := d.ChannelName(id)
+		return Entity{Kind: EntityChannel, ID: id, Name: name}, err
+
+	case EntityUserGroup:
+		if id == "" {
+			var err error
+			if id, err = d.UserGroupID(name); err != nil {
+				return Entity{}, err
+			}
+		}
+		name, err := d.UserGroupName(id)
+		return Entity{Kind: EntityUserGroup, ID: id, Name: name}, err
+	}
+
+	// Bare name or email - user first, then channel, then user group
+	userID, userErr := d.UserID(name)
+	if userErr == nil {
+		name, err := d.UserName(userID)
+		return Entity{Kind: EntityUser, ID: userID, Name: name}, err
+	}
+	channelID, channelErr := d.ChannelID(name)
+	if channelErr == nil {
+		return Entity{Kind: EntityChannel, ID: channelID, Name: name}, nil
+	}
+	groupID, groupErr := d.UserGroupID(name)
+	if groupErr == nil {
+		name, err := d.UserGroupName(groupID)
+		return Entity{Kind: EntityUserGroup, ID: groupID, Name: name}, err
+	}
+
+	var suggestions []string
+	for _, err := range []error{userErr, channelErr, groupErr} {
+		suggestions = append(suggestions, suggestionsOf(err)...)
+	}
+	return Entity{}, &NotFoundError{Name: name, Suggestions: suggestions}
+}
+
+func suggestionsOf(err error) []string {
+	if nf, ok := err.(*NotFoundError); ok {
+		return nf.Suggestions
+	}
+	return nil
+}
+
+// Resolve - resolve many references at once
+func (d *SlackDirectory) Resolve(refs []string) ([]Entity, error) {
+	var entities []Entity
+	var errs ResolveErrors
+	for _, ref := range refs {
+		e, err := d.Lookup(ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entities = append(entities, e)
+	}
+	if len(errs) > 0 {
+		return entities, errs
+	}
+	return entities, nil
+}
+
+// ParseRef - split reference into kind and ID or name. Kind is EntityUnknown for bare names
+func ParseRef(ref string) (kind EntityKind, id string, name string) {
+	ref = strings.TrimSpace(ref)
+
+	// Slack escaped entities: <@U123|bob>, <#C123|general>, <!subteam^S123|@team>
+	if strings.HasPrefix(ref, "<") && strings.HasSuffix(ref, ">") {
+		inner := ref[1 : len(ref)-1]
+		label := ""
+		if i := strings.Index(inner, "|"); i >= 0 {
+			inner, label = inner[:i], inner[i+1:]
+		}
+		switch {
+		case strings.HasPrefix(inner, "@"):
+			return EntityUser, inner[1:], strings.TrimPrefix(label, "@")
+		case strings.HasPrefix(inner, "#"):
+			return EntityChannel, inner[1:], label
+		case strings.HasPrefix(inner, "!subteam^"):
+			return EntityUserGroup, strings.TrimPrefix(inner, "!subteam^"), strings.TrimPrefix(label, "@")
+		case strings.HasPrefix(inner, "mailto:"):
+			// Slack formats emails typed in commands as <mailto:jan@example.com|jan@example.com>
+			return EntityUser, "", strings.TrimPrefix(inner, "mailto:")
+		}
+		return EntityUnknown, "", inner
+	}
+
+	switch {
+	case strings.HasPrefix(ref, "@"):
+		return EntityUser, "", ref[1:]
+	case strings.HasPrefix(ref, "#"):
+		return EntityChannel, "", ref[1:]
+	}
+
+	if isSlackID(ref) {
+		switch ref[0] {
+		case 'U', 'W':
+			return EntityUser, ref, ""
+		case 'C', 'G', 'D':
+			return EntityChannel, ref, ""
+		case 'S':
+			return EntityUserGroup, ref, ""
+		}
+	}
+	return EntityUnknown, "", ref
+}
+
+// isSlackID - looks like Slack ID: upper case letters and digits, at least 9 characters
+func isSlackID(s string) bool {
+	if len(s) < 9 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
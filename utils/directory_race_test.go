@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// Run with -race: readers must never see a snapshot while it is being built
+
+const raceRounds = 200
+
+func testUsers(n int) []slack.User {
+	users := make([]slack.User, n)
+	for i := range users {
+		users[i] = slack.User{ID: fmt.Sprintf("U%03d", i), Name: fmt.Sprintf("user%d", i)}
+	}
+	return users
+}
+
+// hammer - run write and read concurrently raceRounds times each
+func hammer(write func(i int), read func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < raceRounds; i++ {
+			write(i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < raceRounds; i++ {
+			read(i)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestGlobalUsersConcurrentReplace(t *testing.T) {
+	var g GlobalUsers
+	g.replace(testUsers(10))
+
+	hammer(func(i int) {
+		if i%2 == 0 {
+			g.replace(testUsers(10 + i%5))
+		} else {
+			g.SetUser(slack.User{ID: fmt.Sprintf("U%03d", i%10), Name: fmt.Sprintf("renamed%d", i)})
+		}
+		g.SetPresenceByID("U001", "away")
+	}, func(i int) {
+		if _, err := g.IDToName("U000"); err != nil {
+			t.Errorf("IDToName(U000): %v", err)
+		}
+		g.NameToID("user1")
+		g.FindUser("user2")
+		g.GetPresenceByID("U001")
+		_ = len(g.Users())
+	})
+
+	if _, err := g.IDToName("U000"); err != nil {
+		t.Errorf("IDToName(U000) after updates: %v", err)
+	}
+}
+
+func TestGlobalChannelsConcurrentReplace(t *testing.T) {
+	var g GlobalChannels
+	g.SetChannel("C001", "general", KindChannel)
+
+	hammer(func(i int) {
+		switch i % 3 {
+		case 0:
+			g.replace(g.Conversations())
+		case 1:
+			g.SetChannel(fmt.Sprintf("C1%02d", i), fmt.Sprintf("chan%d", i), KindPrivate)
+		default:
+			g.RemoveChannel(fmt.Sprintf("C1%02d", i-1))
+		}
+	}, func(i int) {
+		if id, err := g.NameToID("general"); err != nil || id != "C001" {
+			t.Errorf("NameToID(general) = %s, %v", id, err)
+		}
+		g.IDToName("C001")
+		g.KindOfID("C001")
+		_ = len(g.Conversations(KindChannel))
+	})
+}
+
+func TestGlobalUserGroupsConcurrentReplace(t *testing.T) {
+	var g GlobalUserGroups
+	group := slack.UserGroup{ID: "S001", Handle: "admins", Users: []string{"U001"}}
+	g.replace([]slack.UserGroup{group})
+
+	hammer(func(i int) {
+		switch i % 3 {
+		case 0:
+			g.replace([]slack.UserGroup{group})
+		case 1:
+			g.ChangeMembers("S001", []string{"U002"}, nil)
+		default:
+			g.SetUserGroup(slack.UserGroup{ID: "S002", Handle: fmt.Sprintf("group%d", i)})
+		}
+	}, func(i int) {
+		if !g.IsMember("S001", "U001") {
+			t.Errorf("U001 not in S001")
+		}
+		g.Members("S001")
+		g.NameToID("admins")
+		g.IDToName("S002")
+		_ = len(g.UserGroups())
+	})
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+const maxSuggestions = 3
+
+// Suggest - candidates most similar to query (case insensitive), best first. Used for "did you mean" hints
+func Suggest(query string, candidates []string) []string {
+	query = strings.ToLower(query)
+	if query == "" {
+		return nil
+	}
+
+	type scored struct {
+		value string
+		score int
+	}
+	var matches []scored
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		lc := strings.ToLower(c)
+		if c == "" || seen[lc] {
+			continue
+		}
+		seen[lc] = true
+
+		score := levenshtein(query, lc)
+		if strings.HasPrefix(lc, query) || strings.Contains(lc, query) {
+			// "jan" should find "jan.kowalski" even though they are far apart
+			score = 1
+		}
+		if score <= maxDistance(query) {
+			matches = append(matches, scored{value: c, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		return matches[i].value < matches[j].value
+	})
+
+	var result []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		result = append(result, matches[i].value)
+	}
+	return result
+}
+
+// maxDistance - edits allowed for a suggestion: about one typo per 3 characters, at least 1
+func maxDistance(s string) int {
+	if d := len([]rune(s)) / 3; d > 1 {
+		return d
+	}
+	return 1
+}
+
+// levenshtein - edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		candidates []string
+		want       []string
+	}{
+		{"empty query", "", []string{"jan"}, nil},
+		{"no candidates", "jan", nil, nil},
+		{"one typo, equal distance sorted by name", "jon", []string{"john", "jan", "bob"}, []string{"jan", "john"}},
+		{"substring counts as close", "jan", []string{"xyz", "jan.kowalski", "jam"}, []string{"jam", "jan.kowalski"}},
+		{"closer first", "kowalski", []string{"kovalsky", "kowalsky"}, []string{"kowalsky", "kovalsky"}},
+		{"over distance cutoff", "kowalski", []string{"kavelsky", "nowak"}, nil},
+		{"short query allows one edit", "al", []string{"ab", "xy"}, []string{"ab"}},
+		{"at most three", "ann", []string{"anne", "annie", "anna", "ann-marie"}, []string{"ann-marie", "anna", "anne"}},
+		{"case insensitive, duplicates and empty skipped", "BOB", []string{"bob", "Bob", "", "rob"}, []string{"bob", "rob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Suggest(tt.query, tt.candidates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggest(%q, %q) = %q, want %q", tt.query, tt.candidates, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"łódź", "lodz", 3},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"sort"
+
+	"github.com/nlopes/slack"
+)
+
+// GetMembers - get channel members from Slack (conversations.members) and cache them.
+// Can take long when rate limited - don't call it from RTM loop or slash command handler
+func (g *GlobalChannels) GetMembers(api *slack.Client, id string) ([]string, error) {
+	members, err := GetConversationMembers(api, id)
+	if err != nil {
+		return nil, err
+	}
+	g.membersMu.Lock()
+	defer g.membersMu.Unlock()
+	if g.members == nil {
+		g.members = make(map[string][]string)
+	}
+	g.members[id] = members
+	return members, nil
+}
+
+// CachedMembers - channel members from last GetMembers and member events. False if never fetched.
+// Returned slice is shared - don't modify it
+func (g *GlobalChannels) CachedMembers(id string) ([]string, bool) {
+	g.membersMu.RLock()
+	defer g.membersMu.RUnlock()
+	members, ok := g.members[id]
+	return members, ok
+}
+
+// MemberChannels - IDs of channels with cached members, sorted
+func (g *GlobalChannels) MemberChannels() []string {
+	g.membersMu.RLock()
+	defer g.membersMu.RUnlock()
+	var ids []string
+	for id := range g.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AddMember - user joined channel (member_joined_channel event). Channels without cached members are skipped
+func (g *GlobalChannels) AddMember(id string, user string) {
+	g.membersMu.Lock()
+	defer g.membersMu.Unlock()
+	members, ok := g.members[id]
+	if !ok {
+		return
+	}
+	for _, m := range members {
+		if m == user {
+			return
+		}
+	}
+	// New slice - readers may hold the old one
+	g.members[id] = append(append(make([]string, 0, len(members)+1), members...), user)
+}
+
+// RemoveMember - user left channel (member_left_channel event)
+func (g *GlobalChannels) RemoveMember(id string, user string) {
+	g.membersMu.Lock()
+	defer g.membersMu.Unlock()
+	members, ok := g.members[id]
+	if !ok {
+		return
+	}
+	rest := make([]string, 0, len(members))
+	for _, m := range members {
+		if m != user {
+			rest = append(rest, m)
+		}
+	}
+	g.members[id] = rest
+}
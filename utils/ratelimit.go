@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	// pageSize - items per page in cursor paginated Slack API calls
+	pageSize = 200
+	// maxRateLimitRetries - how many times call is repeated after 429 response
+	maxRateLimitRetries = 10
+)
+
+// RetryRateLimited - run Slack API call, repeating it after Retry-After time on 429 (rate limited) responses
+func RetryRateLimited(method string, call func() error) error {
+	for i := 0; ; i++ {
+		err := call()
+		rateErr, ok := err.(*slack.RateLimitedError)
+		if !ok {
+			return err
+		}
+		if i >= maxRateLimitRetries {
+			return fmt.Errorf("%s still rate limited after %d retries: %v", method, i, err)
+		}
+		log.Printf("%s rate limited, retrying after %s", method, rateErr.RetryAfter)
+		time.Sleep(rateErr.RetryAfter)
+	}
+}
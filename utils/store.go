@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DataPath - path of a state file kept between restarts (in SLACKBOT_DATA_DIR or current dir)
+func DataPath(name string) string {
+	dir := os.Getenv("SLACKBOT_DATA_DIR")
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, name)
+}
+
+// LoadJSON - read JSON file to v. Missing file is not an error - v is left untouched
+func LoadJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SaveJSON - write v as JSON to path. File is replaced atomically, so a crash never leaves half written state.
+// Only the owner can read it - state files hold names, emails and messages
+func SaveJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps mode of a temp file left by a crash
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveJSONOwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory_cache.json")
+	// Temp file left by a crashed save with old mode
+	if err := ioutil.WriteFile(path+".tmp", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveJSON(path, map[string]string{"email": "jan@example.com"}); err != nil {
+		t.Fatalf("SaveJSON: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+
+	var v map[string]string
+	if err := LoadJSON(path, &v); err != nil || v["email"] != "jan@example.com" {
+		t.Errorf("LoadJSON = %v, %v", v, err)
+	}
+}
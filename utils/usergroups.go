@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nlopes/slack"
+)
+
+// userGroupSnapshot - immutable user groups with indexes. Never modified after it is published
+type userGroupSnapshot struct {
+	groups      []slack.UserGroup
+	handleIndex map[string]int
+	idIndex     map[string]int
+	members     map[string]map[string]bool
+}
+
+func newUserGroupSnapshot(groups []slack.UserGroup) *userGroupSnapshot {
+	s := &userGroupSnapshot{
+		groups:      groups,
+		handleIndex: make(map[string]int),
+		idIndex:     make(map[string]int),
+		members:     make(map[string]map[string]bool),
+	}
+	for i := range s.groups {
+		g := &s.groups[i]
+		s.handleIndex[strings.ToLower(g.Handle)] = i
+		s.idIndex[g.ID] = i
+		s.members[g.ID] = make(map[string]bool)
+		for _, u := range g.Users {
+			s.members[g.ID][u] = true
+		}
+	}
+	return s
+}
+
+// clone - copy of groups for copy-on-write update
+func (s *userGroupSnapshot) clone() []slack.UserGroup {
+	return append(make([]slack.UserGroup, 0, len(s.groups)+1), s.groups...)
+}
+
+// GlobalUserGroups - indexed user groups (@platform-team) with members, safe for concurrent use.
+// Readers get the current snapshot without locking, writers build a new snapshot and swap it in
+type GlobalUserGroups struct {
+	writeMu sync.Mutex
+	snap    atomic.Value // *userGroupSnapshot
+}
+
+func (g *GlobalUserGroups) load() *userGroupSnapshot {
+	if s, ok := g.snap.Load().(*userGroupSnapshot); ok {
+		return s
+	}
+	return newUserGroupSnapshot(nil)
+}
+
+// UserGroups - all enabled user groups. Returned slice is shared - don't modify it
+func (g *GlobalUserGroups) UserGroups() []slack.UserGroup {
+	return g.load().groups
+}
+
+// GetUserGroups - get all enabled user groups with members from Slack (usergroups.list).
+// On error last known groups are kept
+func (g *GlobalUserGroups) GetUserGroups(api *slack.Client) error {
+	var groups []slack.UserGroup
+	err := RetryRateLimited("usergroups.list", func() (err error) {
+		groups, err = api.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+		return err
+	})
+	if err != nil {
+		log.Printf("Error getting user groups: %v", err)
+		return err
+	}
+
+	g.replace(groups)
+	return nil
+}
+
+// replace - publish new user groups
+func (g *GlobalUserGroups) replace(groups []slack.UserGroup) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	g.snap.Store(newUserGroupSnapshot(groups))
+}
+
+// SetUserGroup - add or update user group (e.g. from subteam_created or subteam_updated event).
+// Disabled groups are removed
+func (g *GlobalUserGroups) SetUserGroup(group slack.UserGroup) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	old := g.load()
+	groups := old.clone()
+
+	idx, ok := old.idIndex[group.ID]
+	switch {
+	case ok && group.DateDelete != 0:
+		groups = append(groups[:idx], groups[idx+1:]...)
+	case ok:
+		if group.Users == nil {
+			// Event without member list - members didn't change
+			group.Users = groups[idx].Users
+		}
+		groups[idx] = group
+	case group.DateDelete == 0:
+		groups = append(groups, group)
+	default:
+		return
+	}
+	g.snap.Store(newUserGroupSnapshot(groups))
+}
+
+// ChangeMembers - add and remove members of user group (e.g. from subteam_members_changed event)
+func (g *GlobalUserGroups) ChangeMembers(id string, added []string, removed []string) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	old := g.load()
+	idx, ok := old.idIndex[id]
+	if !ok {
+		log.Printf("Unknown user group ID: %s", id)
+		return
+	}
+
+	drop := make(map[string]bool)
+	for _, u := range removed {
+		drop[u] = true
+	}
+	var users []string
+	for _, u := range old.groups[idx].Users {
+		if !drop[u] {
+			users = append(users, u)
+		}
+	}
+	for _, u := range added {
+		if !old.members[id][u] {
+			users = append(users, u)
+		}
+	}
+
+	groups := old.clone()
+	groups[idx].Users = users
+	groups[idx].UserCount = len(users)
+	g.snap.Store(newUserGroupSnapshot(groups))
+}
+
+// IsMember - true if user belongs to user group. False for unknown groups and for IDs which are not groups
+func (g *GlobalUserGroups) IsMember(groupID string, userID string) bool {
+	return g.load().members[groupID][userID]
+}
+
+// Members - user IDs of user group members
+func (g *GlobalUserGroups) Members(id string) ([]string, error) {
+	s := g.load()
+	idx, ok := s.idIndex[id]
+	if !ok {
+		return nil, &NotFoundError{Kind: EntityUserGroup, ID: id}
+	}
+	return s.groups[idx].Users, nil
+}
+
+// NameToID - translate user group handle (without @) to Slack ID
+func (g *GlobalUserGroups) NameToID(handle string) (string, error) {
+	s := g.load()
+	idx, ok := s.handleIndex[strings.ToLower(handle)]
+	if !ok {
+		var handles []string
+		for i := range s.groups {
+			handles = append(handles, s.groups[i].Handle)
+		}
+		var suggestions []string
+		for _, h := range Suggest(handle, handles) {
+			suggestions = append(suggestions, "@"+h)
+		}
+		return "", &NotFoundError{Kind: EntityUserGroup, Name: handle, Suggestions: suggestions}
+	}
+	return s.groups[idx].ID, nil
+}
+
+// IDToName - translate user group ID to handle (without @)
+func (g *GlobalUserGroups) IDToName(id string) (string, error) {
+	s := g.load()
+	idx, ok := s.idIndex[id]
+	if !ok {
+		return "", &NotFoundError{Kind: EntityUserGroup, ID: id}
+	}
+	return s.groups[idx].Handle, nil
+}
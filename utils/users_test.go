@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// usersListServer - users.list with one user per page, every page is rate limited once
+func usersListServer(t *testing.T, pages int) *slack.Client {
+	limited := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.FormValue("cursor")
+		if !limited[cursor] {
+			limited[cursor] = true
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		page := 0
+		fmt.Sscanf(cursor, "page%d", &page)
+		members, next := "[]", ""
+		if page < pages {
+			members = fmt.Sprintf(`[{"id":"U%08d","name":"user%d"}]`, page, page)
+		}
+		if page+1 < pages {
+			next = fmt.Sprintf("page%d", page+1)
+		}
+		fmt.Fprintf(w, `{"ok":true,"members":%s,"response_metadata":{"next_cursor":"%s"}}`, members, next)
+	}))
+	t.Cleanup(srv.Close)
+	return slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+}
+
+func TestGetUsersRetriesRateLimitedPage(t *testing.T) {
+	var g GlobalUsers
+	if err := g.GetUsers(usersListServer(t, 3)); err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if n := len(g.Users()); n != 3 {
+		t.Fatalf("got %d users, want 3 (rate limited page lost)", n)
+	}
+	if _, err := g.IDToName("U00000002"); err != nil {
+		t.Errorf("last page user: %v", err)
+	}
+}
+
+func TestGetUsersKeepsDirectoryOnEmptyList(t *testing.T) {
+	var g GlobalUsers
+	g.SetUser(slack.User{ID: "U00000001", Name: "known"})
+
+	if err := g.GetUsers(usersListServer(t, 0)); err == nil {
+		t.Fatal("empty users list accepted")
+	}
+	if n := len(g.Users()); n != 1 {
+		t.Errorf("directory has %d users after failed refresh, want 1", n)
+	}
+}
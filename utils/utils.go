@@ -1,196 +1,565 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/nlopes/slack"
 )
 
-// GlobalUsers - indexed user space
-type GlobalUsers struct {
-	Users     []slack.User
+// userSnapshot - immutable users list with indexes. Never modified after it is published
+type userSnapshot struct {
+	users     []slack.User
 	nameIndex map[string]int
 	idIndex   map[string]int
+	// aliasIndex - lower case username, display name, real name and email of active users.
+	// Display and real names are not unique, so one alias can point to many users
+	aliasIndex map[string][]int
 }
 
-// GetUsers - get all users to local variable from Slack
-func (g *GlobalUsers) GetUsers(api *slack.Client) {
-	var err error
+func newUserSnapshot(users []slack.User) *userSnapshot {
+	s := &userSnapshot{
+		users:      users,
+		nameIndex:  make(map[string]int),
+		idIndex:    make(map[string]int),
+		aliasIndex: make(map[string][]int),
+	}
+	for i := 0; i < len(s.users); i++ {
+		user := &s.users[i]
+		s.nameIndex[user.Name] = i
+		s.idIndex[user.ID] = i
+		if user.Deleted {
+			continue
+		}
+		for _, alias := range userAliases(user) {
+			s.aliasIndex[alias] = append(s.aliasIndex[alias], i)
+		}
+	}
+	return s
+}
 
-	g.nameIndex = make(map[string]int)
-	g.idIndex = make(map[string]int)
+// userAliases - distinct lower case names user can be referred by
+func userAliases(user *slack.User) []string {
+	var aliases []string
+	for _, a := range []string{user.Name, user.Profile.DisplayName, user.Profile.DisplayNameNormalized,
+		user.RealName, user.Profile.RealName, user.Profile.RealNameNormalized, user.Profile.Email} {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" {
+			continue
+		}
+		dup := false
+		for _, existing := range aliases {
+			if existing == a {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
 
-	g.Users, err = api.GetUsers()
-	if err != nil {
+// UserLabel - name to show to people: display name, real name or username
+func UserLabel(user *slack.User) string {
+	switch {
+	case user.Profile.DisplayName != "":
+		return user.Profile.DisplayName
+	case user.RealName != "":
+		return user.RealName
+	}
+	return user.Name
+}
+
+// GlobalUsers - indexed user space, safe for concurrent use.
+// Readers get the current snapshot without locking, writers build a new snapshot and swap it in
+type GlobalUsers struct {
+	writeMu sync.Mutex
+	snap    atomic.Value // *userSnapshot
+
+	presenceMu sync.RWMutex
+	presence   map[string]string
+}
+
+func (g *GlobalUsers) load() *userSnapshot {
+	if s, ok := g.snap.Load().(*userSnapshot); ok {
+		return s
+	}
+	return newUserSnapshot(nil)
+}
+
+// Users - all users. Returned slice is shared - don't modify it
+func (g *GlobalUsers) Users() []slack.User {
+	return g.load().users
+}
+
+// GetUsers - get all users to local variable from Slack (users.list with cursor pagination).
+// On error last known users are kept
+func (g *GlobalUsers) GetUsers(api *slack.Client) error {
+	var users []slack.User
+
+	p := api.GetUsersPaginated(slack.GetUsersOptionLimit(pageSize))
+	for {
+		err := RetryRateLimited("users.list", func() error {
+			// Failed call returns pagination with empty cursor - keep the current one for retry
+			next, err := p.Next(context.Background())
+			if err == nil {
+				p = next
+			}
+			return err
+		})
+		if p.Done(err) {
+			break
+		}
+		if err != nil {
+			log.Printf("Error getting users: %v", err)
+			return err
+		}
+		users = append(users, p.Users...)
+	}
+	if len(users) == 0 {
+		// Workspace always has users - empty list would wipe the directory and its cache
+		err := errors.New("users.list returned no users")
 		log.Printf("Error getting users: %v", err)
+		return err
 	}
 
-	for i := 0; i < len(g.Users); i++ {
-		user := g.Users[i]
-		g.nameIndex[user.Name] = i
-		g.idIndex[user.ID] = i
+	g.replace(users)
+	return nil
+}
+
+// replace - publish new users list
+func (g *GlobalUsers) replace(users []slack.User) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	g.snap.Store(newUserSnapshot(users))
+}
+
+// SetUser - add new user or update existing one (e.g. from team_join or user_change event)
+func (g *GlobalUsers) SetUser(user slack.User) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	old := g.load()
+	users := make([]slack.User, len(old.users), len(old.users)+1)
+	copy(users, old.users)
+
+	if idx, ok := old.idIndex[user.ID]; ok {
+		users[idx] = user
+	} else {
+		users = append(users, user)
 	}
+	g.snap.Store(newUserSnapshot(users))
 }
 
 // SetPresenceByID - set user presence in local variable (not in Slack)
 func (g *GlobalUsers) SetPresenceByID(id string, presence string) {
-	idx, ok := g.idIndex[id]
-	if !ok {
+	if _, ok := g.load().idIndex[id]; !ok {
 		log.Printf("Unknown input ID: %s", id)
 		return
 	}
 
-	g.Users[idx].Presence = presence
+	g.presenceMu.Lock()
+	defer g.presenceMu.Unlock()
+	if g.presence == nil {
+		g.presence = make(map[string]string)
+	}
+	g.presence[id] = presence
+}
+
+// presenceOf - last presence set by SetPresenceByID, presence from Slack user list otherwise
+func (g *GlobalUsers) presenceOf(user slack.User) string {
+	g.presenceMu.RLock()
+	defer g.presenceMu.RUnlock()
+	if p, ok := g.presence[user.ID]; ok {
+		return p
+	}
+	return user.Presence
 }
 
 // GetPresenceByName - get user presence from local variable (not from Slack)
-func (g *GlobalUsers) GetPresenceByName(name string) string {
-	idx, ok := g.nameIndex[name]
+func (g *GlobalUsers) GetPresenceByName(name string) (string, error) {
+	s := g.load()
+	idx, ok := s.nameIndex[name]
 	if !ok {
-		log.Printf("Unknown input name: %s", name)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_PRESENCE_FROM_NAME_%s", name)
+		return "", &NotFoundError{Kind: EntityUser, Name: name}
 	}
-	return g.Users[idx].Presence
+	return g.presenceOf(s.users[idx]), nil
 }
 
 // GetPresenceByID - get user presence from local variable (not from Slack)
-func (g *GlobalUsers) GetPresenceByID(id string) string {
-	idx, ok := g.idIndex[id]
+func (g *GlobalUsers) GetPresenceByID(id string) (string, error) {
+	s := g.load()
+	idx, ok := s.idIndex[id]
 	if !ok {
-		log.Printf("Unknown input ID: %s", id)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_PRESENCE_FROM_ID_%s", id)
+		return "", &NotFoundError{Kind: EntityUser, ID: id}
 	}
-	return g.Users[idx].Presence
+	return g.presenceOf(s.users[idx]), nil
 }
 
-// NameToID - translate Slack name (without @) to Slack ID
-func (g *GlobalUsers) NameToID(name string) string {
-	idx, ok := g.nameIndex[name]
-	if !ok {
-		log.Printf("Unknown input name: %s", name)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_ID_FROM_NAME_%s", name)
+// FindUser - find user by ID, username, email, display name or real name (case insensitive).
+// If nothing matches exactly (or many users share the name), error has suggestions
+func (g *GlobalUsers) FindUser(query string) (slack.User, error) {
+	s := g.load()
+	query = strings.TrimSpace(query)
+
+	if idx, ok := s.idIndex[query]; ok {
+		return s.users[idx], nil
+	}
+	if idx, ok := s.nameIndex[query]; ok {
+		return s.users[idx], nil
+	}
+
+	matches := s.aliasIndex[strings.ToLower(query)]
+	if len(matches) == 1 {
+		return s.users[matches[0]], nil
 	}
-	return g.Users[idx].ID
+
+	var suggestions []string
+	if len(matches) > 1 {
+		for _, idx := range matches {
+			suggestions = append(suggestions, "@"+s.users[idx].Name)
+		}
+	} else {
+		var aliases []string
+		for alias := range s.aliasIndex {
+			aliases = append(aliases, alias)
+		}
+		for _, alias := range Suggest(query, aliases) {
+			suggestions = append(suggestions, "@"+s.users[s.aliasIndex[alias][0]].Name)
+		}
+	}
+	return slack.User{}, &NotFoundError{Kind: EntityUser, Name: query, Suggestions: dedup(suggestions)}
+}
+
+// NameToID - translate user name (without @) to Slack ID. Name can be username, display name, real name or email
+func (g *GlobalUsers) NameToID(name string) (string, error) {
+	user, err := g.FindUser(name)
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
 }
 
 // IDToName - translate Slack ID  to Slack name (without @)
-func (g *GlobalUsers) IDToName(id string) string {
-	idx, ok := g.idIndex[id]
+func (g *GlobalUsers) IDToName(id string) (string, error) {
+	s := g.load()
+	idx, ok := s.idIndex[id]
 	if !ok {
-		log.Printf("Unknown input ID: %s", id)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_NAME_FROM_ID_%s", id)
+		return "", &NotFoundError{Kind: EntityUser, ID: id}
+	}
+	return s.users[idx].Name, nil
+}
+
+// ConversationKind - type of Slack conversation (as in conversations.list types filter)
+type ConversationKind string
+
+// Conversation kinds
+const (
+	KindChannel ConversationKind = "public_channel"
+	KindPrivate ConversationKind = "private_channel"
+	KindMPIM    ConversationKind = "mpim"
+	KindIM      ConversationKind = "im"
+)
+
+// AllConversationKinds - kinds fetched to the directory
+var AllConversationKinds = []ConversationKind{KindChannel, KindPrivate, KindMPIM, KindIM}
+
+// KindOf - kind of conversation
+func KindOf(c *slack.Channel) ConversationKind {
+	switch {
+	case c.IsIM:
+		return KindIM
+	case c.IsMpIM:
+		return KindMPIM
+	case c.IsPrivate || c.IsGroup:
+		return KindPrivate
 	}
-	return g.Users[idx].Name
+	return KindChannel
 }
 
-type idxGrpChan struct {
-	t string
-	i int
+// conversationName - name used in name index. IMs have no name - user ID of the other side is used
+func conversationName(c *slack.Channel) string {
+	if c.IsIM {
+		return c.User
+	}
+	return c.Name
 }
 
-// GlobalChannels - indexed channel space
+// channelSnapshot - immutable conversations with indexes. Never modified after it is published
+type channelSnapshot struct {
+	conversations []slack.Channel
+	nameIndex     map[string]int
+	idIndex       map[string]int
+}
+
+func newChannelSnapshot(conversations []slack.Channel) *channelSnapshot {
+	s := &channelSnapshot{
+		conversations: conversations,
+		nameIndex:     make(map[string]int),
+		idIndex:       make(map[string]int),
+	}
+
+	for i := 0; i < len(s.conversations); i++ {
+		c := &s.conversations[i]
+		// log.Printf("Conversation Name: %s, ID: %s, Kind: %s\n", c.Name, c.ID, KindOf(c))
+		s.nameIndex[conversationName(c)] = i
+		s.idIndex[c.ID] = i
+	}
+	return s
+}
+
+// clone - copy of conversations for copy-on-write update
+func (s *channelSnapshot) clone() []slack.Channel {
+	return append(make([]slack.Channel, 0, len(s.conversations)+1), s.conversations...)
+}
+
+// GlobalChannels - indexed channel space (public and private channels, MPIMs and IMs), safe for concurrent use.
+// Readers get the current snapshot without locking, writers build a new snapshot and swap it in
 type GlobalChannels struct {
-	Channels  []slack.Channel
-	Groups    []slack.Group
-	IMs       []slack.IM
-	nameIndex map[string]idxGrpChan
-	idIndex   map[string]idxGrpChan
+	writeMu sync.Mutex
+	snap    atomic.Value // *channelSnapshot
+
+	// members - channel ID to member IDs fetched with GetMembers and kept current from events.
+	// conversations.list doesn't return members, so only channels asked for are here
+	membersMu sync.RWMutex
+	members   map[string][]string
 }
 
-// GetChannels - get all channels to local variable from Slack
-func (g *GlobalChannels) GetChannels(api *slack.Client) {
-	var err error
+func (g *GlobalChannels) load() *channelSnapshot {
+	if s, ok := g.snap.Load().(*channelSnapshot); ok {
+		return s
+	}
+	return newChannelSnapshot(nil)
+}
 
-	g.nameIndex = make(map[string]idxGrpChan)
-	g.idIndex = make(map[string]idxGrpChan)
+// Conversations - all conversations of given kinds (all kinds if none given)
+func (g *GlobalChannels) Conversations(kinds ...ConversationKind) []slack.Channel {
+	all := g.load().conversations
+	if len(kinds) == 0 {
+		return all
+	}
 
-	g.Channels, err = api.GetChannels(true)
-	if err != nil {
-		log.Printf("Error getting channels: %v", err)
-		return
+	var list []slack.Channel
+	for i := range all {
+		kind := KindOf(&all[i])
+		for _, k := range kinds {
+			if kind == k {
+				list = append(list, all[i])
+				break
+			}
+		}
 	}
+	return list
+}
 
-	for i := 0; i < len(g.Channels); i++ {
-		channel := g.Channels[i]
-		// log.Printf("Channel Name: %s, ID: %s, IsChannel: %t\n", channel.Name, channel.ID, channel.IsChannel)
-		g.nameIndex[channel.Name] = idxGrpChan{t: "c", i: i}
-		g.idIndex[channel.ID] = idxGrpChan{t: "c", i: i}
+// GetChannels - get all conversations to local variable from Slack (conversations.list with cursor pagination).
+// On error last known channels are kept
+func (g *GlobalChannels) GetChannels(api *slack.Client) error {
+	var types []string
+	for _, kind := range AllConversationKinds {
+		types = append(types, string(kind))
 	}
 
-	g.Groups, err = api.GetGroups(true)
-	if err != nil {
-		log.Printf("Error getting groups: %v", err)
-		return
+	var conversations []slack.Channel
+	params := &slack.GetConversationsParameters{Types: types, Limit: pageSize, ExcludeArchived: "true"}
+	for {
+		var page []slack.Channel
+		var cursor string
+		err := RetryRateLimited("conversations.list", func() (err error) {
+			page, cursor, err = api.GetConversations(params)
+			return err
+		})
+		if err != nil {
+			log.Printf("Error getting conversations: %v", err)
+			return err
+		}
+		conversations = append(conversations, page...)
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
 	}
 
-	for i := 0; i < len(g.Groups); i++ {
-		group := g.Groups[i]
-		// log.Printf("Group Name: %s, ID: %s, IsChannel: %t\n", group.Name, group.ID, false)
-		g.nameIndex[group.Name] = idxGrpChan{t: "g", i: i}
-		g.idIndex[group.ID] = idxGrpChan{t: "g", i: i}
+	g.replace(conversations)
+	return nil
+}
+
+// GetConversationMembers - user IDs of conversation members from Slack (conversations.members with cursor pagination)
+func GetConversationMembers(api *slack.Client, channelID string) ([]string, error) {
+	var members []string
+	params := &slack.GetUsersInConversationParameters{ChannelID: channelID, Limit: pageSize}
+	for {
+		var page []string
+		var cursor string
+		err := RetryRateLimited("conversations.members", func() (err error) {
+			page, cursor, err = api.GetUsersInConversation(params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if cursor == "" {
+			return members, nil
+		}
+		params.Cursor = cursor
 	}
+}
 
-	g.IMs, err = api.GetIMChannels()
-	if err != nil {
-		log.Printf("Error getting IM channels: %v", err)
-		return
+// GetThread - messages of thread started by message with ts, parent first (conversations.replies with cursor pagination)
+func GetThread(api *slack.Client, channelID string, ts string) ([]slack.Message, error) {
+	var messages []slack.Message
+	params := &slack.GetConversationRepliesParameters{ChannelID: channelID, Timestamp: ts, Limit: pageSize}
+	for {
+		var page []slack.Message
+		var hasMore bool
+		var cursor string
+		err := RetryRateLimited("conversations.replies", func() (err error) {
+			page, hasMore, cursor, err = api.GetConversationReplies(params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if !hasMore || cursor == "" {
+			return messages, nil
+		}
+		params.Cursor = cursor
 	}
+}
+
+// replace - publish new conversations
+func (g *GlobalChannels) replace(conversations []slack.Channel) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	g.snap.Store(newChannelSnapshot(conversations))
+}
 
-	for i := 0; i < len(g.IMs); i++ {
-		im := g.IMs[i]
-		// log.Printf("IM Name: %s, ID: %s, IsChannel: %t\n", im.User, im.ID, false)
-		g.nameIndex[im.User] = idxGrpChan{t: "d", i: i}
-		g.idIndex[im.ID] = idxGrpChan{t: "d", i: i}
+// SetChannel - add new conversation or rename existing one (e.g. from channel_created or channel_rename event)
+func (g *GlobalChannels) SetChannel(id string, name string, kind ConversationKind) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	old := g.load()
+	conversations := old.clone()
+
+	if idx, ok := old.idIndex[id]; ok {
+		if conversations[idx].IsIM {
+			// IMs have no name to change
+			return
+		}
+		conversations[idx].Name = name
+	} else {
+		c := slack.Channel{}
+		c.ID = id
+		c.Name = name
+		c.IsChannel = kind == KindChannel
+		c.IsPrivate = kind == KindPrivate || kind == KindMPIM
+		c.IsGroup = kind == KindPrivate
+		c.IsMpIM = kind == KindMPIM
+		conversations = append(conversations, c)
 	}
+	g.snap.Store(newChannelSnapshot(conversations))
+}
 
+// SetIM - add direct message channel with user (e.g. from im_created event)
+func (g *GlobalChannels) SetIM(id string, user string) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	old := g.load()
+	if _, ok := old.idIndex[id]; ok {
+		return
+	}
+
+	im := slack.Channel{}
+	im.ID = id
+	im.User = user
+	im.IsIM = true
+	g.snap.Store(newChannelSnapshot(append(old.clone(), im)))
 }
 
-// NameToID - translate Slack name (without @) to Slack ID
-func (g *GlobalChannels) NameToID(name string) string {
-	var id string
+// RemoveChannel - remove conversation (e.g. from channel_deleted event)
+func (g *GlobalChannels) RemoveChannel(id string) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
 
-	idxStruct, ok := g.nameIndex[name]
+	old := g.load()
+	idx, ok := old.idIndex[id]
 	if !ok {
-		log.Printf("Unknown input name: %s", name)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_ID_FROM_NAME_%s", name)
+		return
 	}
+	conversations := old.clone()
+	conversations = append(conversations[:idx], conversations[idx+1:]...)
+	g.snap.Store(newChannelSnapshot(conversations))
 
-	if idxStruct.t == "c" {
-		id = g.Channels[idxStruct.i].ID
-	} else if idxStruct.t == "g" {
-		id = g.Groups[idxStruct.i].ID
-	} else if idxStruct.t == "d" {
-		id = g.IMs[idxStruct.i].ID
-	}
+	g.membersMu.Lock()
+	delete(g.members, id)
+	g.membersMu.Unlock()
+}
 
-	return id
+// KindOfID - kind of conversation with given ID ("" if unknown)
+func (g *GlobalChannels) KindOfID(id string) ConversationKind {
+	s := g.load()
+	idx, ok := s.idIndex[id]
+	if !ok {
+		return ""
+	}
+	return KindOf(&s.conversations[idx])
 }
 
-// IDToName - translate Slack ID  to Slack name (without @)
-func (g *GlobalChannels) IDToName(id string) string {
-	var name string
+// NameToID - translate Slack name (without #) to Slack ID. IMs are found by user ID of the other side
+func (g *GlobalChannels) NameToID(name string) (string, error) {
+	s := g.load()
+	idx, ok := s.nameIndex[name]
+	if !ok {
+		idx, ok = s.nameIndex[strings.ToLower(name)]
+	}
+	if !ok {
+		var names []string
+		for i := range s.conversations {
+			if !s.conversations[i].IsIM && !s.conversations[i].IsMpIM {
+				names = append(names, s.conversations[i].Name)
+			}
+		}
+		var suggestions []string
+		for _, n := range Suggest(name, names) {
+			suggestions = append(suggestions, "#"+n)
+		}
+		return "", &NotFoundError{Kind: EntityChannel, Name: name, Suggestions: suggestions}
+	}
+	return s.conversations[idx].ID, nil
+}
 
-	idxStruct, ok := g.idIndex[id]
+// IDToName - translate Slack ID  to Slack name (without #). For IMs user ID of the other side is returned
+func (g *GlobalChannels) IDToName(id string) (string, error) {
+	s := g.load()
+	idx, ok := s.idIndex[id]
 	if !ok {
-		log.Printf("Unknown input ID: %s", id)
-		// return translation result as below
-		return fmt.Sprintf("UNKNOWN_NAME_FROM_ID_%s", id)
+		return "", &NotFoundError{Kind: EntityChannel, ID: id}
 	}
-	if idxStruct.t == "c" {
-		name = g.Channels[idxStruct.i].Name
-	} else if idxStruct.t == "g" {
-		name = g.Groups[idxStruct.i].Name
-	} else if idxStruct.t == "d" {
-		id = g.IMs[idxStruct.i].User
+	return conversationName(&s.conversations[idx]), nil
+}
+
+// dedup - values without repetitions, order kept
+func dedup(values []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
 	}
-	return name
+	return result
 }
 
 // StructPrettyPrint - JSON like